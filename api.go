@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+func getAggregateHandler(w http.ResponseWriter, r *http.Request) {
+	state := h.AggregateState()
+	if state == nil {
+		http.Error(w, "no global aggregate yet", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, state)
+}
+
+func getTreesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.TreeStates())
+}
+
+func getPeersHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.PeerStates())
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,165 @@
+package clock
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Fake only moves when Advance is called. Functions passed to AfterFunc run
+// on the goroutine that calls Advance, in the order of their deadlines and,
+// for equal deadlines, in the order they were scheduled. Timers scheduled
+// while advancing fire in the same call if they are due by its end.
+type Fake struct {
+	now    time.Time
+	timers fakeTimers
+	seq    uint64
+	lock   *sync.Mutex
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{
+		now:  now,
+		lock: new(sync.Mutex),
+	}
+}
+
+func (c *Fake) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// Sleep blocks until another goroutine advances the clock by d.
+func (c *Fake) Sleep(d time.Duration) {
+	done := make(chan struct{})
+	c.AfterFunc(d, func() { close(done) })
+	<-done
+}
+
+func (c *Fake) AfterFunc(d time.Duration, f func()) Timer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.schedule(d, f)
+}
+
+func (c *Fake) schedule(d time.Duration, f func()) *fakeTimer {
+	c.seq++
+	t := &fakeTimer{at: c.now.Add(d), seq: c.seq, fn: f, clock: c}
+	heap.Push(&c.timers, t)
+	return t
+}
+
+// NewTicker behaves like time.NewTicker, ticks are dropped if the previous
+// one has not been received yet.
+func (c *Fake) NewTicker(d time.Duration) Ticker {
+	t := &fakeTicker{c: make(chan time.Time, 1), clock: c}
+	var tick func()
+	tick = func() {
+		select {
+		case t.c <- c.Now():
+		default:
+		}
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if !t.stopped {
+			t.timer = c.schedule(d, tick)
+		}
+	}
+	c.lock.Lock()
+	t.timer = c.schedule(d, tick)
+	c.lock.Unlock()
+	return t
+}
+
+// Advance moves the clock forward by d and runs every function that
+// becomes due.
+func (c *Fake) Advance(d time.Duration) {
+	c.lock.Lock()
+	end := c.now.Add(d)
+	for len(c.timers) > 0 && !c.timers[0].at.After(end) {
+		t := heap.Pop(&c.timers).(*fakeTimer)
+		if t.stopped {
+			continue
+		}
+		t.fired = true
+		c.now = t.at
+		c.lock.Unlock()
+		t.fn()
+		c.lock.Lock()
+	}
+	c.now = end
+	c.lock.Unlock()
+}
+
+// Pending returns the number of scheduled functions that have not run yet.
+func (c *Fake) Pending() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	pending := 0
+	for _, t := range c.timers {
+		if !t.stopped {
+			pending++
+		}
+	}
+	return pending
+}
+
+type fakeTimer struct {
+	at      time.Time
+	seq     uint64
+	fn      func()
+	fired   bool
+	stopped bool
+	clock   *Fake
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	if t.fired || t.stopped {
+		return false
+	}
+	t.stopped = true
+	return true
+}
+
+type fakeTicker struct {
+	c       chan time.Time
+	timer   *fakeTimer
+	stopped bool
+	clock   *Fake
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	t.stopped = true
+	t.timer.stopped = true
+}
+
+type fakeTimers []*fakeTimer
+
+func (q fakeTimers) Len() int { return len(q) }
+
+func (q fakeTimers) Less(i, j int) bool {
+	if !q[i].at.Equal(q[j].at) {
+		return q[i].at.Before(q[j].at)
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q fakeTimers) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *fakeTimers) Push(x any) { *q = append(*q, x.(*fakeTimer)) }
+
+func (q *fakeTimers) Pop() any {
+	old := *q
+	t := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return t
+}
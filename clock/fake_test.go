@@ -0,0 +1,138 @@
+package clock
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+var epoch = time.Unix(0, 0)
+
+func TestAdvanceRunsTimersInOrder(t *testing.T) {
+	c := NewFake(epoch)
+	var order []string
+	at := func(name string) func() {
+		return func() { order = append(order, name+"@"+c.Now().Sub(epoch).String()) }
+	}
+	c.AfterFunc(3*time.Second, at("c"))
+	c.AfterFunc(time.Second, at("a"))
+	c.AfterFunc(2*time.Second, at("b1"))
+	c.AfterFunc(2*time.Second, at("b2"))
+	c.AfterFunc(5*time.Second, at("late"))
+
+	c.Advance(3 * time.Second)
+	want := []string{"a@1s", "b1@2s", "b2@2s", "c@3s"}
+	if !slices.Equal(order, want) {
+		t.Fatalf("ran %v, want %v", order, want)
+	}
+	if got := c.Now().Sub(epoch); got != 3*time.Second {
+		t.Fatalf("now is %s, want 3s", got)
+	}
+	if c.Pending() != 1 {
+		t.Fatalf("%d timers pending, want 1", c.Pending())
+	}
+}
+
+func TestAdvanceRunsTimersScheduledWhileAdvancing(t *testing.T) {
+	c := NewFake(epoch)
+	var fired []time.Duration
+	var tick func()
+	tick = func() {
+		fired = append(fired, c.Now().Sub(epoch))
+		c.AfterFunc(time.Second, tick)
+	}
+	c.AfterFunc(time.Second, tick)
+
+	c.Advance(3500 * time.Millisecond)
+	want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	if !slices.Equal(fired, want) {
+		t.Fatalf("fired at %v, want %v", fired, want)
+	}
+	c.Advance(500 * time.Millisecond)
+	if len(fired) != 4 || fired[3] != 4*time.Second {
+		t.Fatalf("fired at %v, want a fourth time at 4s", fired)
+	}
+}
+
+func TestAdvanceRunsZeroDelayTimersAfterDueOnes(t *testing.T) {
+	c := NewFake(epoch)
+	var order []string
+	c.AfterFunc(time.Second, func() {
+		order = append(order, "first")
+		c.AfterFunc(0, func() { order = append(order, "now") })
+	})
+	c.AfterFunc(time.Second, func() { order = append(order, "second") })
+
+	c.Advance(time.Second)
+	if want := []string{"first", "second", "now"}; !slices.Equal(order, want) {
+		t.Fatalf("ran %v, want %v", order, want)
+	}
+}
+
+func TestStoppedTimerDoesNotRun(t *testing.T) {
+	c := NewFake(epoch)
+	ran := false
+	timer := c.AfterFunc(time.Second, func() { ran = true })
+	if !timer.Stop() {
+		t.Fatal("Stop of a pending timer returned false")
+	}
+	c.Advance(2 * time.Second)
+	if ran {
+		t.Fatal("stopped timer ran")
+	}
+	if timer.Stop() {
+		t.Fatal("second Stop returned true")
+	}
+	fired := c.AfterFunc(time.Second, func() {})
+	c.Advance(time.Second)
+	if fired.Stop() {
+		t.Fatal("Stop of a fired timer returned true")
+	}
+}
+
+func TestTicker(t *testing.T) {
+	c := NewFake(epoch)
+	ticker := c.NewTicker(time.Second)
+	c.Advance(time.Second)
+	if got := (<-ticker.C()).Sub(epoch); got != time.Second {
+		t.Fatalf("tick at %s, want 1s", got)
+	}
+	// ticks that are not received are dropped
+	c.Advance(3 * time.Second)
+	if got := (<-ticker.C()).Sub(epoch); got != 2*time.Second {
+		t.Fatalf("tick at %s, want 2s", got)
+	}
+	select {
+	case tick := <-ticker.C():
+		t.Fatalf("unexpected tick at %s", tick.Sub(epoch))
+	default:
+	}
+	ticker.Stop()
+	c.Advance(time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("tick after Stop")
+	default:
+	}
+	if c.Pending() != 0 {
+		t.Fatalf("%d timers pending after Stop", c.Pending())
+	}
+}
+
+func TestSleepWakesOnAdvance(t *testing.T) {
+	c := NewFake(epoch)
+	woke := make(chan struct{})
+	go func() {
+		c.Sleep(time.Second)
+		close(woke)
+	}()
+	for c.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.Advance(time.Second)
+	select {
+	case <-woke:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sleep did not return")
+	}
+}
@@ -0,0 +1,327 @@
+// Command sim runs a Hidera cluster in one process on a virtual clock.
+//
+// Protocol parameters are read from the environment like for a real node
+// (T_AGG, R_MAX, METRICS, AGG_FUNCS, ...), the simulation itself is
+// configured with flags.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/tamararankovic/hidera/config"
+	"github.com/tamararankovic/hidera/hidera"
+	"github.com/tamararankovic/hidera/peers"
+)
+
+const port = 8000
+
+// simConfig holds the flags that configure a simulation.
+type simConfig struct {
+	n          int
+	m          int
+	seed       int64
+	duration   time.Duration
+	stagger    time.Duration
+	minLatency time.Duration
+	maxLatency time.Duration
+	loss       float64
+	values     string
+	eps        float64
+	sample     time.Duration
+	seeds      int
+	leaveRoot  time.Duration
+}
+
+func main() {
+	var c simConfig
+	flag.IntVar(&c.n, "n", 100, "number of nodes")
+	flag.IntVar(&c.m, "m", 3, "target average degree of the topology")
+	flag.Int64Var(&c.seed, "seed", 1, "random seed")
+	flag.DurationVar(&c.duration, "duration", 2*time.Minute, "simulated time")
+	flag.DurationVar(&c.stagger, "stagger", 0, "nodes start at random times in [0, stagger)")
+	flag.DurationVar(&c.minLatency, "min-latency", time.Millisecond, "minimum message latency")
+	flag.DurationVar(&c.maxLatency, "max-latency", 10*time.Millisecond, "maximum message latency")
+	flag.Float64Var(&c.loss, "loss", 0, "probability that a message is lost")
+	flag.StringVar(&c.values, "values", "id", "local values, \"id\" or \"uniform\" in [0, 100)")
+	flag.Float64Var(&c.eps, "eps", 0.01, "relative error under which a node counts as converged")
+	flag.DurationVar(&c.sample, "sample", time.Second, "interval between accuracy samples")
+	out := flag.String("out", "", "write the accuracy time series as CSV to this file")
+	flag.IntVar(&c.seeds, "seeds", 0, "if set, nodes start without peers and join through nodes 1..seeds, keeping m peers")
+	flag.DurationVar(&c.leaveRoot, "leave-root", 0, "the root of the best tree leaves gracefully at this time")
+	verbose := flag.Bool("v", false, "keep the protocol logs")
+	flag.Parse()
+
+	// the protocol logs go to the standard logger, errors of the
+	// simulator itself must stay visible when those are discarded
+	errLog := log.New(os.Stderr, "", log.LstdFlags)
+
+	params := config.LoadParamsFromEnv()
+	var series io.Writer
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			errLog.Fatalln(err)
+		}
+		defer f.Close()
+		series = f
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	res, err := simulate(c, params, series)
+	if err != nil {
+		errLog.Fatalln(err)
+	}
+
+	edges := 0
+	for _, nd := range res.nodes {
+		edges += len(nd.peers.GetPeers())
+	}
+	edges /= 2
+
+	fmt.Printf("nodes                 %d\n", len(res.nodes))
+	fmt.Printf("edges                 %d (average degree %.2f)\n", edges, 2*float64(edges)/float64(len(res.nodes)))
+	fmt.Printf("simulated time        %s\n", c.duration)
+	fmt.Printf("aggregate             %s(%s)\n", res.aggFunc.Name(), res.metric)
+	fmt.Printf("true value            %g\n", res.truth)
+	fmt.Printf("converged at          %s\n", formatTime(res.convergedAt))
+	fmt.Printf("stable since          %s\n", formatTime(res.stableSince))
+	fmt.Printf("nodes with result     %d\n", res.last.withResult)
+	fmt.Printf("trees                 %d\n", res.last.trees)
+	fmt.Printf("mean relative error   %g\n", res.last.meanErr)
+	fmt.Printf("max relative error    %g\n", res.last.maxErr)
+	fmt.Printf("messages sent         %d\n", peers.MessagesSent)
+	fmt.Printf("messages received     %d\n", peers.MessagesRcvd)
+	fmt.Printf("bytes sent            %d\n", res.bytesSent)
+	rounds := c.duration.Seconds() / float64(params.Tagg)
+	fmt.Printf("messages/node/round   %.2f\n", float64(peers.MessagesSent)/float64(len(res.nodes))/rounds)
+	types := make([]byte, 0, len(peers.MessagesSentByType))
+	for t := range peers.MessagesSentByType {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	for _, t := range types {
+		fmt.Printf("  %-19s %d\n", hidera.MsgTypeName(int8(t)), peers.MessagesSentByType[t])
+	}
+}
+
+type simResult struct {
+	nodes       []*node
+	metric      string
+	aggFunc     hidera.AggFunc
+	truth       float64
+	convergedAt *time.Duration
+	stableSince *time.Duration
+	last        accuracy
+	bytesSent   int
+}
+
+// simulate runs a cluster and writes the accuracy time series to series if
+// it is not nil. Runs with the same config and seed are identical.
+func simulate(c simConfig, params config.Params, series io.Writer) (simResult, error) {
+	if len(params.Metrics) == 0 || params.Metrics[0] == hidera.AllMetrics {
+		return simResult{}, errors.New("METRICS must name the metric to simulate")
+	}
+	if len(params.AggFuncs) == 0 {
+		return simResult{}, errors.New("AGG_FUNCS must not be empty")
+	}
+	metric := params.Metrics[0]
+	aggFunc, err := hidera.GetAggFunc(params.AggFuncs[0])
+	if err != nil {
+		return simResult{}, err
+	}
+	if c.values != "id" && c.values != "uniform" {
+		return simResult{}, fmt.Errorf("unknown value distribution %q", c.values)
+	}
+	if series != nil {
+		fmt.Fprintln(series, "time_s,nodes_with_result,trees,mean_err,max_err,msgs_sent")
+	}
+
+	sim := NewSimulator(c.seed)
+	sim.Network.MinLatency = c.minLatency
+	sim.Network.MaxLatency = c.maxLatency
+	sim.Network.Loss = c.loss
+
+	r := rand.New(rand.NewSource(c.seed))
+	topology := RandomTopology(c.n, c.m, r)
+	nodes := make([]*node, 0, c.n)
+	for i, neighbours := range topology {
+		id := strconv.Itoa(i + 1)
+		conf := config.Config{
+			NodeID:     id,
+			ListenIP:   nodeHost(i + 1),
+			ListenPort: port,
+		}
+		if c.seeds > 0 {
+			conf.TargetPeers = c.m
+			for j := 1; j <= c.seeds; j++ {
+				if j != i+1 {
+					conf.Seeds = append(conf.Seeds, nodeAddr(j))
+				}
+			}
+		} else {
+			for _, j := range neighbours {
+				conf.PeersIDs = append(conf.PeersIDs, strconv.Itoa(j))
+				conf.PeersAddrs = append(conf.PeersAddrs, nodeAddr(j))
+			}
+		}
+		transport := sim.Network.Transport(nodeAddr(i + 1))
+		p := params
+		p.ID = id
+		h, err := hidera.New(
+			hidera.WithParams(p),
+			hidera.WithPeerConfig(conf),
+			hidera.WithTransport(transport),
+			hidera.WithClock(sim.Clock),
+		)
+		if err != nil {
+			return simResult{}, err
+		}
+		transport.Handler = h.HandlePacket
+		h.Rand = rand.New(rand.NewSource(c.seed + int64(i) + 1))
+		h.Peers.Rand = rand.New(rand.NewSource(r.Int63()))
+		if c.values == "uniform" {
+			h.SetValue(metric, r.Float64()*100)
+		}
+		nodes = append(nodes, &node{h: h, peers: h.Peers})
+	}
+	truth := trueResult(nodes, metric, aggFunc)
+
+	tagg := time.Duration(params.Tagg) * time.Second
+	var startErr error
+	for _, nd := range nodes {
+		start := time.Duration(r.Int63n(int64(tagg)))
+		if c.stagger > 0 {
+			start += time.Duration(r.Int63n(int64(c.stagger)))
+		}
+		sim.After(start, func() {
+			if err := nd.h.Start(context.Background()); err != nil && startErr == nil {
+				startErr = err
+			}
+		})
+	}
+	if c.leaveRoot > 0 {
+		sim.After(c.leaveRoot, func() {
+			i := slices.IndexFunc(nodes, func(nd *node) bool {
+				return slices.ContainsFunc(nd.h.TreeStates(), func(t hidera.TreeState) bool {
+					return t.IsRoot && t.IsBest
+				})
+			})
+			if i < 0 {
+				return
+			}
+			nodes[i].h.Stop()
+			nodes = slices.Delete(nodes, i, i+1)
+			truth = trueResult(nodes, metric, aggFunc)
+		})
+	}
+
+	// counted from here, so that runs in one process report the same
+	peers.MessagesSentLock.Lock()
+	sentBefore := peers.MessagesSent
+	peers.MessagesSentLock.Unlock()
+	res := simResult{metric: metric, aggFunc: aggFunc}
+	for t := c.sample; t <= c.duration; t += c.sample {
+		sim.RunUntil(t)
+		if startErr != nil {
+			return simResult{}, startErr
+		}
+		res.last = measure(nodes, metric, aggFunc.Name(), truth)
+		converged := res.last.withResult == len(nodes) && res.last.maxErr <= c.eps
+		if converged {
+			if res.convergedAt == nil {
+				res.convergedAt = &t
+			}
+			if res.stableSince == nil {
+				res.stableSince = &t
+			}
+		} else {
+			res.stableSince = nil
+		}
+		if series != nil {
+			peers.MessagesSentLock.Lock()
+			sent := peers.MessagesSent - sentBefore
+			peers.MessagesSentLock.Unlock()
+			fmt.Fprintf(series, "%g,%d,%d,%g,%g,%d\n", t.Seconds(), res.last.withResult, res.last.trees,
+				res.last.meanErr, res.last.maxErr, sent)
+		}
+	}
+	res.nodes = nodes
+	res.truth = truth
+	res.bytesSent = sim.Network.BytesSent()
+	return res, nil
+}
+
+func nodeHost(i int) string {
+	return "node_" + strconv.Itoa(i)
+}
+
+func nodeAddr(i int) string {
+	return nodeHost(i) + ":" + strconv.Itoa(port)
+}
+
+// trueResult aggregates the local values of all nodes in one place, which is
+// what every node should converge to.
+func trueResult(nodes []*node, metric string, f hidera.AggFunc) float64 {
+	aggs := make([]hidera.Aggregate, 0, len(nodes))
+	for _, nd := range nodes {
+		aggs = append(aggs, hidera.NewAggregate(map[string]float64{metric: nd.h.Values[metric]}, 0))
+	}
+	total := aggs[0].Aggregate(aggs[1:])
+	return f.Result(total.Metrics[metric])
+}
+
+type accuracy struct {
+	withResult int
+	trees      int
+	meanErr    float64
+	maxErr     float64
+}
+
+func measure(nodes []*node, metric, fn string, truth float64) accuracy {
+	var acc accuracy
+	trees := make(map[string]struct{})
+	sum := 0.0
+	for _, nd := range nodes {
+		state := nd.h.AggregateState()
+		if state == nil {
+			continue
+		}
+		result, ok := state.Results[metric][fn]
+		if !ok {
+			continue
+		}
+		trees[state.TreeID] = struct{}{}
+		acc.withResult++
+		e := math.Abs(result - truth)
+		if truth != 0 {
+			e /= math.Abs(truth)
+		}
+		sum += e
+		acc.maxErr = math.Max(acc.maxErr, e)
+	}
+	acc.trees = len(trees)
+	if acc.withResult > 0 {
+		acc.meanErr = sum / float64(acc.withResult)
+	}
+	return acc
+}
+
+func formatTime(t *time.Duration) string {
+	if t == nil {
+		return "never"
+	}
+	return t.String()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"time"
+
+	"github.com/tamararankovic/hidera/clock"
+	"github.com/tamararankovic/hidera/hidera"
+	"github.com/tamararankovic/hidera/peers"
+)
+
+type node struct {
+	h     *hidera.Hidera
+	peers *peers.Peers
+}
+
+// Simulator runs nodes on a fake clock. Everything happens on the goroutine
+// that calls RunUntil, the network delivers messages on the same clock as
+// the rounds and elections of the nodes, straight to Hidera.HandlePacket.
+type Simulator struct {
+	Clock   *clock.Fake
+	Network *peers.MemNetwork
+	start   time.Time
+}
+
+func NewSimulator(seed int64) *Simulator {
+	start := time.Unix(0, 0)
+	s := &Simulator{
+		Clock:   clock.NewFake(start),
+		Network: peers.NewMemNetwork(seed),
+		start:   start,
+	}
+	s.Network.Clock = s.Clock
+	return s
+}
+
+func (s *Simulator) Elapsed() time.Duration {
+	return s.Clock.Now().Sub(s.start)
+}
+
+func (s *Simulator) After(d time.Duration, f func()) {
+	s.Clock.AfterFunc(d, f)
+}
+
+func (s *Simulator) RunUntil(end time.Duration) {
+	s.Clock.Advance(end - s.Elapsed())
+}
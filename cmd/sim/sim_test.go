@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hidera/config"
+)
+
+func testParams() config.Params {
+	return config.Params{
+		Tagg:            1,
+		Telect:          1,
+		Rmax:            3,
+		Rwindow:         10,
+		Rfull:           6,
+		Threshold:       5,
+		Metrics:         []string{"app_memory_usage_bytes"},
+		AggFuncs:        []string{"avg"},
+		SketchAlpha:     0.01,
+		SketchMaxBins:   64,
+		HllPrecision:    8,
+		WireFormat:      "json",
+		FailureDetector: "rounds",
+		Rgrace:          10,
+	}
+}
+
+// TestSimulationIsDeterministic runs the same simulation twice, with joins
+// through seeds, lossy links and a root that leaves.
+func TestSimulationIsDeterministic(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	c := simConfig{
+		n:          30,
+		m:          3,
+		seed:       7,
+		duration:   40 * time.Second,
+		stagger:    3 * time.Second,
+		minLatency: time.Millisecond,
+		maxLatency: 20 * time.Millisecond,
+		loss:       0.02,
+		values:     "uniform",
+		eps:        0.01,
+		sample:     time.Second,
+		seeds:      3,
+		leaveRoot:  25 * time.Second,
+	}
+	run := func(c simConfig) (simResult, string) {
+		var series strings.Builder
+		res, err := simulate(c, testParams(), &series)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, nd := range res.nodes {
+			nd.h.Stop()
+		}
+		return res, series.String()
+	}
+
+	first, firstSeries := run(c)
+	second, secondSeries := run(c)
+	if firstSeries != secondSeries {
+		t.Fatalf("series of two runs differ:\n%s\n%s", firstSeries, secondSeries)
+	}
+	if first.bytesSent != second.bytesSent {
+		t.Fatalf("runs sent %d and %d bytes", first.bytesSent, second.bytesSent)
+	}
+	if first.convergedAt == nil {
+		t.Fatalf("simulation did not converge:\n%s", firstSeries)
+	}
+
+	c.seed++
+	if _, other := run(c); other == firstSeries {
+		t.Fatal("runs with different seeds are identical")
+	}
+}
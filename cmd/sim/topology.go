@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+)
+
+// RandomTopology builds the same kind of graph as run.sh: every node is
+// connected to a random node with a smaller ID, which gives a spanning
+// tree, and random edges are added until the average degree reaches m.
+// Nodes are numbered from 1, the result holds the neighbours of node i at
+// index i-1.
+func RandomTopology(n, m int, r *rand.Rand) [][]int {
+	adj := make([]map[int]struct{}, n+1)
+	for i := range adj {
+		adj[i] = make(map[int]struct{})
+	}
+	edges := 0
+	addEdge := func(a, b int) {
+		if a == b {
+			return
+		}
+		if _, ok := adj[a][b]; ok {
+			return
+		}
+		adj[a][b] = struct{}{}
+		adj[b][a] = struct{}{}
+		edges++
+	}
+
+	for i := 2; i <= n; i++ {
+		addEdge(i, r.Intn(i-1)+1)
+	}
+
+	target := min(m*n/2, n*(n-1)/2)
+	for edges < target {
+		addEdge(r.Intn(n)+1, r.Intn(n)+1)
+	}
+
+	topology := make([][]int, n)
+	for i := 1; i <= n; i++ {
+		for j := range adj[i] {
+			topology[i-1] = append(topology[i-1], j)
+		}
+	}
+	for _, neighbours := range topology {
+		slices.Sort(neighbours)
+	}
+	return topology
+}
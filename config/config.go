@@ -1,22 +1,34 @@
 package config
 
 import (
+	"encoding/hex"
 	"log"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
-	ListenIP   string
-	ListenPort int
-	PeersIDs   []string
-	PeersIPs   []string
+	NodeID         string
+	ListenIP       string
+	ListenPort     int
+	Transport      string
+	PeersIDs       []string
+	PeersAddrs     []string
+	ClusterKey     []byte
+	PrevClusterKey []byte
+	KeyGrace       time.Duration
+	EncryptionKey  []byte
+	PeersKeys      [][]byte
+	Seeds          []string
+	TargetPeers    int
+	PassivePeers   int
 }
 
 func LoadConfigFromEnv() Config {
-	listenIP := os.Getenv("LISTEN_IP")
+	listenIP := strings.Trim(os.Getenv("LISTEN_IP"), "[]")
 	listenHost := os.Getenv("LISTEN_HOST")
 	listenPortStr := os.Getenv("LISTEN_PORT")
 
@@ -42,26 +54,84 @@ func LoadConfigFromEnv() Config {
 	}
 
 	c := Config{
+		NodeID:     os.Getenv("ID"),
 		ListenIP:   listenIP,
 		ListenPort: listenPort,
+		Transport:  os.Getenv("TRANSPORT"),
+	}
+
+	// messages are signed only if a cluster key is set, a previous key is
+	// still used for KEY_GRACE_PERIOD after startup to allow rotation
+	if key := os.Getenv("CLUSTER_KEY"); key != "" {
+		c.ClusterKey = []byte(key)
+	}
+	if key := os.Getenv("CLUSTER_KEY_PREV"); key != "" {
+		c.PrevClusterKey = []byte(key)
+	}
+	c.KeyGrace = 10 * time.Minute
+	if graceStr := os.Getenv("KEY_GRACE_PERIOD"); graceStr != "" {
+		grace, err := time.ParseDuration(graceStr)
+		if err != nil {
+			log.Fatalf("invalid key grace period %q: %v\n", graceStr, err)
+		}
+		c.KeyGrace = grace
+	}
+	if c.PrevClusterKey != nil && c.ClusterKey == nil {
+		log.Fatalln("error: CLUSTER_KEY_PREV is set without CLUSTER_KEY")
+	}
+	if c.ClusterKey != nil && len(c.NodeID) > 255 {
+		log.Fatalln("error: ID must be at most 255 bytes when CLUSTER_KEY is set")
+	}
+
+	// ENCRYPTION_KEY is shared by the cluster, PEER_ENCRYPTION_KEYS overrides
+	// it for individual peers, both are hex encoded AES-128/192/256 keys
+	if keyStr := os.Getenv("ENCRYPTION_KEY"); keyStr != "" {
+		c.EncryptionKey = parseAESKey(keyStr)
+	}
+
+	// SEEDS are "host" or "host:port" addresses of nodes that a node
+	// without peers asks to join. TARGET_PEERS is the size of the active
+	// view, PASSIVE_PEERS of the passive one, which defaults to six times
+	// the active view.
+	for _, seed := range splitAndTrim(os.Getenv("SEEDS")) {
+		c.Seeds = append(c.Seeds, withPort(seed, listenPort))
+	}
+	c.TargetPeers = 4
+	if targetStr := os.Getenv("TARGET_PEERS"); targetStr != "" {
+		target, err := strconv.Atoi(targetStr)
+		if err != nil || target < 1 {
+			log.Fatalf("invalid target peers %q\n", targetStr)
+		}
+		c.TargetPeers = target
+	}
+	if passiveStr := os.Getenv("PASSIVE_PEERS"); passiveStr != "" {
+		passive, err := strconv.Atoi(passiveStr)
+		if err != nil || passive < 1 {
+			log.Fatalf("invalid passive peers %q\n", passiveStr)
+		}
+		c.PassivePeers = passive
 	}
 
 	peerIDsStr := os.Getenv("PEER_IDS")
 	peerIPsStr := os.Getenv("PEER_IPS")
 	peerHostsStr := os.Getenv("PEER_HOSTS")
 
-	if peerIDsStr == "" {
-		log.Fatalln("error: PEER_IDS is not set")
+	if peerIDsStr == "" && len(c.Seeds) == 0 {
+		log.Fatalln("error: neither PEER_IDS nor SEEDS is set")
 	}
 
 	peerIDs := splitAndTrim(peerIDsStr)
 	peerIPs := splitAndTrim(peerIPsStr)
 	peerHosts := splitAndTrim(peerHostsStr)
+	peerKeys := splitAndTrim(os.Getenv("PEER_ENCRYPTION_KEYS"))
 
 	maxLen := len(peerIDs)
 	ensureSameLength(&peerIPs, maxLen)
 	ensureSameLength(&peerHosts, maxLen)
+	ensureSameLength(&peerKeys, maxLen)
 
+	// PEER_IPS and PEER_HOSTS entries may carry a port, as in "host:port" or
+	// "[::1]:port", peers without one listen on LISTEN_PORT
 	for i := range maxLen {
 		id := peerIDs[i]
 		ip := peerIPs[i]
@@ -71,14 +141,23 @@ func LoadConfigFromEnv() Config {
 			log.Fatalf("error: peer %q has neither IP nor host\n", id)
 		}
 		if ip == "" {
+			host, port, err := net.SplitHostPort(withPort(host, listenPort))
+			if err != nil {
+				log.Fatalf("invalid host %q for peer %q: %v\n", peerHosts[i], id, err)
+			}
 			addrs, err := net.LookupHost(host)
 			if err != nil || len(addrs) == 0 {
 				log.Fatalf("error resolving host %q for peer %q: %v\n", host, id, err)
 			}
-			ip = addrs[0]
+			ip = net.JoinHostPort(addrs[0], port)
+		}
+		key := c.EncryptionKey
+		if peerKeys[i] != "" {
+			key = parseAESKey(peerKeys[i])
 		}
 		c.PeersIDs = append(c.PeersIDs, id)
-		c.PeersIPs = append(c.PeersIPs, ip)
+		c.PeersAddrs = append(c.PeersAddrs, withPort(ip, listenPort))
+		c.PeersKeys = append(c.PeersKeys, key)
 	}
 	if !c.isValid() {
 		log.Fatalln("config invalid", c)
@@ -87,7 +166,27 @@ func LoadConfigFromEnv() Config {
 }
 
 func (c Config) isValid() bool {
-	return len(c.PeersIDs) == len(c.PeersIPs)
+	return len(c.PeersIDs) == len(c.PeersAddrs) && len(c.PeersIDs) == len(c.PeersKeys)
+}
+
+// withPort adds port to addr unless it already has one. IPv6 addresses
+// without a port may be written with or without brackets.
+func withPort(addr string, port int) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), strconv.Itoa(port))
+}
+
+func parseAESKey(s string) []byte {
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		log.Fatalf("invalid encryption key: %v\n", err)
+	}
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		log.Fatalf("invalid encryption key length %d, must be 16, 24 or 32 bytes\n", len(key))
+	}
+	return key
 }
 
 func splitAndTrim(s string) []string {
@@ -7,13 +7,30 @@ import (
 )
 
 type Params struct {
-	ID        string `env:"ID"`
-	Tagg      int    `env:"T_AGG"      envDefault:"1"`
-	Telect    int    `env:"T_ELECT"    envDefault:"1"`
-	Rmax      int    `env:"R_MAX"      envDefault:"3"`
-	Rwindow   int    `env:"R_WINDOW"   envDefault:"10"`
-	Rfull     int    `env:"R_FULL"     envDefault:"6"`
-	Threshold int    `env:"THRESHOLD"  envDefault:"5"`
+	ID            string   `env:"ID"`
+	Tagg          int      `env:"T_AGG"           envDefault:"1"`
+	Telect        int      `env:"T_ELECT"         envDefault:"1"`
+	Rmax          int      `env:"R_MAX"           envDefault:"3"`
+	Rwindow       int      `env:"R_WINDOW"        envDefault:"10"`
+	Rfull         int      `env:"R_FULL"          envDefault:"6"`
+	Threshold     int      `env:"THRESHOLD"       envDefault:"5"`
+	Metrics       []string `env:"METRICS"         envDefault:"app_memory_usage_bytes"`
+	GroupBy       []string `env:"GROUP_BY"`
+	AggFuncs      []string `env:"AGG_FUNCS"       envDefault:"avg"`
+	SketchAlpha   float64  `env:"SKETCH_ALPHA"    envDefault:"0.01"`
+	SketchMaxBins int      `env:"SKETCH_MAX_BINS" envDefault:"64"`
+	Distinct      bool     `env:"DISTINCT"        envDefault:"false"`
+	HllPrecision  int      `env:"HLL_PRECISION"   envDefault:"8"`
+	WireFormat    string   `env:"WIRE_FORMAT"     envDefault:"json"`
+	// FAILURE_DETECTOR is "rounds", which fails peers silent for R_MAX
+	// rounds, or "phi". Peers known at startup are not failed before R_GRACE
+	// rounds. PHI_MIN_STDDEV and PHI_ACCEPTABLE_PAUSE are in seconds.
+	FailureDetector string  `env:"FAILURE_DETECTOR"     envDefault:"rounds"`
+	Rgrace          int     `env:"R_GRACE"              envDefault:"10"`
+	PhiThreshold    float64 `env:"PHI_THRESHOLD"        envDefault:"8"`
+	PhiWindow       int     `env:"PHI_WINDOW"           envDefault:"100"`
+	PhiMinStdDev    float64 `env:"PHI_MIN_STDDEV"       envDefault:"0.25"`
+	PhiPause        float64 `env:"PHI_ACCEPTABLE_PAUSE" envDefault:"1"`
 }
 
 func LoadParamsFromEnv() Params {
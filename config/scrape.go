@@ -0,0 +1,30 @@
+package config
+
+import (
+	"log"
+	"time"
+
+	"github.com/caarlos0/env"
+)
+
+type ScrapeConfig struct {
+	URL      string        `env:"SCRAPE_URL"`
+	Interval time.Duration `env:"SCRAPE_INTERVAL" envDefault:"5s"`
+	Timeout  time.Duration `env:"SCRAPE_TIMEOUT"  envDefault:"2s"`
+	Metrics  []string      `env:"SCRAPE_METRICS"`
+}
+
+func LoadScrapeConfigFromEnv() ScrapeConfig {
+	var c ScrapeConfig
+	if err := env.Parse(&c); err != nil {
+		log.Fatalln(err)
+	}
+	if c.URL != "" && (c.Interval <= 0 || c.Timeout <= 0) {
+		log.Fatalln("error: SCRAPE_INTERVAL and SCRAPE_TIMEOUT must be positive")
+	}
+	return c
+}
+
+func (c ScrapeConfig) Enabled() bool {
+	return c.URL != ""
+}
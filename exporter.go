@@ -0,0 +1,145 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/tamararankovic/hidera/hidera"
+	"github.com/tamararankovic/hidera/metrics"
+	"github.com/tamararankovic/hidera/peers"
+)
+
+func getMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	families := make([]metrics.Family, 0)
+	gauge := func(name, help string, samples ...metrics.Sample) {
+		families = append(families, metrics.Family{Name: name, Help: help, Type: metrics.Gauge, Samples: samples})
+	}
+	counter := func(name, help string, samples ...metrics.Sample) {
+		families = append(families, metrics.Family{Name: name, Help: help, Type: metrics.Counter, Samples: samples})
+	}
+	sample := func(name string, value float64, labels map[string]string) metrics.Sample {
+		return metrics.Sample{Name: name, Labels: labels, Value: value}
+	}
+
+	agg := h.AggregateState()
+	results := make([]metrics.Sample, 0)
+	if agg != nil {
+		for _, key := range slices.Sorted(maps.Keys(agg.Results)) {
+			for _, f := range slices.Sorted(maps.Keys(agg.Results[key])) {
+				results = append(results, sample("hidera_aggregate", agg.Results[key][f], map[string]string{"metric": key, "func": f}))
+			}
+		}
+	}
+	gauge("hidera_aggregate", "Global aggregate of a metric computed by an aggregation function.", results...)
+	if agg != nil {
+		gauge("hidera_aggregate_round", "Round in which the global aggregate was computed by the root.",
+			sample("hidera_aggregate_round", float64(agg.Round), nil))
+		gauge("hidera_aggregate_freshness_rounds", "Rounds since the last global aggregate was received.",
+			sample("hidera_aggregate_freshness_rounds", float64(agg.Freshness), nil))
+		if agg.Distinct != nil {
+			gauge("hidera_distinct_estimate", "Estimated number of distinct items seen cluster-wide.",
+				sample("hidera_distinct_estimate", *agg.Distinct, nil))
+		}
+	}
+
+	h.Lock.Lock()
+	round := h.Round
+	countEstimate := h.CountEstimate
+	h.Lock.Unlock()
+	gauge("hidera_round", "Current local round.", sample("hidera_round", float64(round), nil))
+	gauge("hidera_count_estimate", "Estimated number of nodes.", sample("hidera_count_estimate", float64(countEstimate), nil))
+
+	trees := h.TreeStates()
+	gauge("hidera_trees", "Number of trees the node takes part in.", sample("hidera_trees", float64(len(trees)), nil))
+	for _, tree := range trees {
+		if !tree.IsBest {
+			continue
+		}
+		labels := map[string]string{"tree": tree.ID}
+		isRoot := 0.0
+		if tree.IsRoot {
+			isRoot = 1
+		}
+		gauge("hidera_tree_level", "Level of the node in the best tree.", sample("hidera_tree_level", float64(tree.Level), labels))
+		gauge("hidera_tree_is_root", "Whether the node is the root of the best tree.", sample("hidera_tree_is_root", isRoot, labels))
+		gauge("hidera_tree_children", "Number of children in the best tree.", sample("hidera_tree_children", float64(len(tree.Children)), labels))
+		gauge("hidera_tree_lazy_peers", "Number of lazy peers in the best tree.", sample("hidera_tree_lazy_peers", float64(len(tree.Lazy)), labels))
+	}
+
+	peerStates := h.PeerStates()
+	failed, suspect := 0, 0
+	for _, p := range peerStates {
+		if p.Failed {
+			failed++
+		} else if p.Suspect {
+			suspect++
+		}
+	}
+	gauge("hidera_peers", "Number of configured peers by liveness.",
+		sample("hidera_peers", float64(len(peerStates)-failed-suspect), map[string]string{"state": "active"}),
+		sample("hidera_peers", float64(suspect), map[string]string{"state": "suspect"}),
+		sample("hidera_peers", float64(failed), map[string]string{"state": "failed"}))
+	suspicion := make([]metrics.Sample, 0, len(peerStates))
+	for _, p := range peerStates {
+		suspicion = append(suspicion, sample("hidera_peer_suspicion", p.Suspicion, map[string]string{"peer": p.ID}))
+	}
+	gauge("hidera_peer_suspicion", "Failure detector suspicion level by peer.", suspicion...)
+
+	peers.MessagesSentLock.Lock()
+	sent := maps.Clone(peers.MessagesSentByType)
+	peers.MessagesSentLock.Unlock()
+	peers.MessagesRcvdLock.Lock()
+	rcvd := maps.Clone(peers.MessagesRcvdByType)
+	peers.MessagesRcvdLock.Unlock()
+	counter("hidera_messages_sent_total", "Messages sent by message type.", msgCountSamples("hidera_messages_sent_total", sent)...)
+	counter("hidera_messages_received_total", "Messages received by message type.", msgCountSamples("hidera_messages_received_total", rcvd)...)
+
+	hidera.DecodeErrorsLock.Lock()
+	decodeErrors := maps.Clone(hidera.DecodeErrors)
+	hidera.DecodeErrorsLock.Unlock()
+	counter("hidera_messages_rejected_total", "Received messages that could not be decoded by reason.",
+		reasonSamples("hidera_messages_rejected_total", decodeErrors)...)
+
+	peers.PacketsRejectedLock.Lock()
+	packetsRejected := maps.Clone(peers.PacketsRejected)
+	peers.PacketsRejectedLock.Unlock()
+	counter("hidera_packets_rejected_total", "Received datagrams dropped by the peer layer by reason.",
+		reasonSamples("hidera_packets_rejected_total", packetsRejected)...)
+
+	peers.PacketsDroppedLock.Lock()
+	packetsDropped := maps.Clone(peers.PacketsDropped)
+	peers.PacketsDroppedLock.Unlock()
+	counter("hidera_packets_dropped_total", "Outgoing datagrams dropped by the peer layer by reason.",
+		reasonSamples("hidera_packets_dropped_total", packetsDropped)...)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := metrics.Write(w, families); err != nil {
+		log.Println(err)
+	}
+}
+
+func msgCountSamples(name string, counts map[byte]int) []metrics.Sample {
+	samples := make([]metrics.Sample, 0, len(counts))
+	for _, t := range slices.Sorted(maps.Keys(counts)) {
+		samples = append(samples, metrics.Sample{
+			Name:   name,
+			Labels: map[string]string{"type": hidera.MsgTypeName(int8(t))},
+			Value:  float64(counts[t]),
+		})
+	}
+	return samples
+}
+
+func reasonSamples(name string, counts map[string]int) []metrics.Sample {
+	samples := make([]metrics.Sample, 0, len(counts))
+	for _, reason := range slices.Sorted(maps.Keys(counts)) {
+		samples = append(samples, metrics.Sample{
+			Name:   name,
+			Labels: map[string]string{"reason": reason},
+			Value:  float64(counts[reason]),
+		})
+	}
+	return samples
+}
@@ -0,0 +1,146 @@
+package hidera
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// AggFunc computes a result from the merged partial of a metric. All
+// functions share the same partial state, so any number of them can be
+// reported from one tree.
+type AggFunc interface {
+	Name() string
+	Result(agg MetricAgg) float64
+}
+
+type sumFunc struct{}
+
+func (sumFunc) Name() string { return "sum" }
+
+func (sumFunc) Result(agg MetricAgg) float64 {
+	return agg.Value
+}
+
+type countFunc struct{}
+
+func (countFunc) Name() string { return "count" }
+
+func (countFunc) Result(agg MetricAgg) float64 {
+	return float64(agg.Count)
+}
+
+type avgFunc struct{}
+
+func (avgFunc) Name() string { return "avg" }
+
+func (avgFunc) Result(agg MetricAgg) float64 {
+	if agg.Count == 0 {
+		return math.NaN()
+	}
+	return agg.Value / float64(agg.Count)
+}
+
+// meanFunc is avg under the name it was requested with.
+type meanFunc struct{ avgFunc }
+
+func (meanFunc) Name() string { return "mean" }
+
+type minFunc struct{}
+
+func (minFunc) Name() string { return "min" }
+
+func (minFunc) Result(agg MetricAgg) float64 {
+	if agg.Count == 0 {
+		return math.NaN()
+	}
+	return agg.Min
+}
+
+type maxFunc struct{}
+
+func (maxFunc) Name() string { return "max" }
+
+func (maxFunc) Result(agg MetricAgg) float64 {
+	if agg.Count == 0 {
+		return math.NaN()
+	}
+	return agg.Max
+}
+
+type varianceFunc struct{}
+
+func (varianceFunc) Name() string { return "variance" }
+
+func (varianceFunc) Result(agg MetricAgg) float64 {
+	if agg.Count == 0 {
+		return math.NaN()
+	}
+	// population variance
+	return agg.M2 / float64(agg.Count)
+}
+
+// quantileFunc reads a quantile from the aggregate's sketch. It is the only
+// function that needs the sketch, so the sketch is sent only if one is
+// configured.
+type quantileFunc struct {
+	name string
+	q    float64
+}
+
+func (f quantileFunc) Name() string { return f.name }
+
+func (f quantileFunc) Result(agg MetricAgg) float64 {
+	if agg.Sketch == nil {
+		return math.NaN()
+	}
+	return agg.Sketch.Quantile(f.q)
+}
+
+var aggFuncs = map[string]AggFunc{
+	"sum":      sumFunc{},
+	"count":    countFunc{},
+	"avg":      avgFunc{},
+	"mean":     meanFunc{},
+	"min":      minFunc{},
+	"max":      maxFunc{},
+	"variance": varianceFunc{},
+}
+
+func GetAggFunc(name string) (AggFunc, error) {
+	// p50, p95, p99.9, ...
+	if percentile, ok := strings.CutPrefix(name, "p"); ok {
+		p, err := strconv.ParseFloat(percentile, 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("invalid quantile function %q", name)
+		}
+		return quantileFunc{name: name, q: p / 100}, nil
+	}
+	f, ok := aggFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregation function %q", name)
+	}
+	return f, nil
+}
+
+func GetAggFuncs(names []string) ([]AggFunc, error) {
+	funcs := make([]AggFunc, 0, len(names))
+	for _, name := range names {
+		f, err := GetAggFunc(name)
+		if err != nil {
+			return nil, err
+		}
+		funcs = append(funcs, f)
+	}
+	return funcs, nil
+}
+
+func needsSketch(funcs []AggFunc) bool {
+	for _, f := range funcs {
+		if _, ok := f.(quantileFunc); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package hidera
+
+import (
+	"math"
+	"testing"
+)
+
+// aggregateOf merges the values in parts, like the partials of a tree.
+func aggregateOf(parts ...[]float64) MetricAgg {
+	var total MetricAgg
+	for _, part := range parts {
+		var agg MetricAgg
+		for _, v := range part {
+			agg = agg.merge(NewMetricAgg(v))
+		}
+		total = total.merge(agg)
+	}
+	return total
+}
+
+func TestAggFuncs(t *testing.T) {
+	// the variance of 4, 7, 13, 16 is 22.5, shifted by 1e9 the sum of
+	// squares cancels it out entirely
+	const shift = 1e9
+	values := []float64{shift + 4, shift + 7, shift + 13, shift + 16}
+	splits := map[string][][]float64{
+		"single": {values},
+		"pairs":  {values[:2], values[2:]},
+		"uneven": {values[:1], values[1:]},
+		"each":   {values[:1], values[1:2], values[2:3], values[3:]},
+		"empty":  {nil, values[:3], nil, values[3:]},
+	}
+	want := map[string]float64{
+		"sum":      4*shift + 40,
+		"count":    4,
+		"avg":      shift + 10,
+		"mean":     shift + 10,
+		"min":      shift + 4,
+		"max":      shift + 16,
+		"variance": 22.5,
+	}
+	for split, parts := range splits {
+		agg := aggregateOf(parts...)
+		for name, w := range want {
+			f, err := GetAggFunc(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Name() != name {
+				t.Errorf("%s reports its name as %s", name, f.Name())
+			}
+			if got := f.Result(agg); math.Abs(got-w) > 1e-6 {
+				t.Errorf("%s of %s split values = %g, want %g", name, split, got, w)
+			}
+		}
+	}
+}
+
+func TestAggFuncsOfNothing(t *testing.T) {
+	for _, name := range []string{"avg", "min", "max", "variance"} {
+		f, _ := GetAggFunc(name)
+		if got := f.Result(MetricAgg{}); !math.IsNaN(got) {
+			t.Errorf("%s of no values = %g, want NaN", name, got)
+		}
+	}
+}
+
+func TestGetAggFuncRejectsUnknownNames(t *testing.T) {
+	for _, name := range []string{"median", "p101", "p-1", "px"} {
+		if _, err := GetAggFunc(name); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
@@ -1,15 +1,100 @@
 package hidera
 
+import (
+	"maps"
+	"math"
+)
+
+// MetricAgg is the partial aggregate of a single named metric. M2 is the sum
+// of the squared differences from the mean, which unlike the sum of squares
+// does not lose the variance to cancellation when values are large.
+type MetricAgg struct {
+	Value  float64
+	Count  int
+	Min    float64
+	Max    float64
+	M2     float64
+	Sketch *QuantileSketch `json:",omitempty"`
+}
+
+func NewMetricAgg(value float64) MetricAgg {
+	return MetricAgg{
+		Value: value,
+		Count: 1,
+		Min:   value,
+		Max:   value,
+	}
+}
+
+// merge returns a new partial, the sketch of the receiver is never modified.
+func (m MetricAgg) merge(o MetricAgg) MetricAgg {
+	if o.Sketch != nil {
+		if m.Sketch == nil {
+			m.Sketch = o.Sketch.Clone()
+		} else {
+			m.Sketch = m.Sketch.Clone()
+			m.Sketch.Merge(o.Sketch)
+		}
+	}
+	if o.Count == 0 {
+		return m
+	}
+	if m.Count == 0 {
+		m.Min = o.Min
+		m.Max = o.Max
+		m.M2 = o.M2
+	} else {
+		m.Min = math.Min(m.Min, o.Min)
+		m.Max = math.Max(m.Max, o.Max)
+		// Chan et al.'s parallel variance
+		delta := o.Value/float64(o.Count) - m.Value/float64(m.Count)
+		m.M2 += o.M2 + delta*delta*float64(m.Count)*float64(o.Count)/float64(m.Count+o.Count)
+	}
+	m.Value += o.Value
+	m.Count += o.Count
+	return m
+}
+
 type Aggregate struct {
-	Value float64
-	Count int
-	Round int
+	Metrics  map[string]MetricAgg
+	Count    int
+	Distinct *HyperLogLog
+	Round    int
+}
+
+func NewAggregate(values map[string]float64, round int) Aggregate {
+	metrics := make(map[string]MetricAgg, len(values))
+	for name, value := range values {
+		metrics[name] = NewMetricAgg(value)
+	}
+	return Aggregate{
+		Metrics: metrics,
+		Count:   1,
+		Round:   round,
+	}
 }
 
 func (a Aggregate) Aggregate(other []Aggregate) Aggregate {
+	// sketches are shared with the receiver's copies, merge into new ones
+	a.Metrics = maps.Clone(a.Metrics)
+	if a.Metrics == nil {
+		a.Metrics = make(map[string]MetricAgg)
+	}
+	if a.Distinct != nil {
+		a.Distinct = a.Distinct.Clone()
+	}
 	for _, o := range other {
-		a.Value += o.Value
+		if o.Distinct != nil {
+			if a.Distinct == nil {
+				a.Distinct = o.Distinct.Clone()
+			} else {
+				a.Distinct.Merge(o.Distinct)
+			}
+		}
+		for name, m := range o.Metrics {
+			a.Metrics[name] = a.Metrics[name].merge(m)
+		}
 		a.Count += o.Count
 	}
 	return a
-}
\ No newline at end of file
+}
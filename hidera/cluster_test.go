@@ -0,0 +1,189 @@
+package hidera
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hidera/clock"
+	"github.com/tamararankovic/hidera/config"
+	"github.com/tamararankovic/hidera/peers"
+)
+
+const testMetric = "app_memory_usage_bytes"
+
+func quietLogs(t testing.TB) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+func testParams(id string) config.Params {
+	return config.Params{
+		ID:              id,
+		Tagg:            1,
+		Telect:          1,
+		Rmax:            3,
+		Rwindow:         10,
+		Rfull:           6,
+		Threshold:       5,
+		Metrics:         []string{testMetric},
+		AggFuncs:        []string{"avg"},
+		SketchAlpha:     0.01,
+		SketchMaxBins:   64,
+		HllPrecision:    8,
+		WireFormat:      JSON_WIRE_FORMAT,
+		FailureDetector: ROUNDS_FAILURE_DETECTOR,
+		Rgrace:          10,
+	}
+}
+
+func testAddr(i int) string {
+	return "node_" + strconv.Itoa(i) + ":8000"
+}
+
+// newTestCluster starts n nodes on a ring with chords to the node halfway
+// across, all on one fake clock. Node i has the value i.
+func newTestCluster(t *testing.T, n int, network *peers.MemNetwork, c *clock.Fake) []*Hidera {
+	t.Helper()
+	nodes := make([]*Hidera, 0, n)
+	for i := 1; i <= n; i++ {
+		conf := config.Config{NodeID: strconv.Itoa(i), ListenIP: "node_" + strconv.Itoa(i), ListenPort: 8000}
+		for _, j := range []int{i%n + 1, (i+n-2)%n + 1, (i+n/2-1)%n + 1} {
+			if j == i || slices.Contains(conf.PeersIDs, strconv.Itoa(j)) {
+				continue
+			}
+			conf.PeersIDs = append(conf.PeersIDs, strconv.Itoa(j))
+			conf.PeersAddrs = append(conf.PeersAddrs, testAddr(j))
+		}
+		transport := network.Transport(testAddr(i))
+		h, err := New(
+			WithParams(testParams(conf.NodeID)),
+			WithPeerConfig(conf),
+			WithTransport(transport),
+			WithClock(c),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport.Handler = h.HandlePacket
+		h.SetValue(testMetric, float64(i))
+		nodes = append(nodes, h)
+	}
+	for _, h := range nodes {
+		if err := h.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, h := range nodes {
+			h.Stop()
+		}
+	})
+	return nodes
+}
+
+// maxError returns the largest relative error of the average over all
+// nodes, and false if a node has no result yet.
+func maxError(nodes []*Hidera, mean float64) (float64, bool) {
+	maxErr := 0.0
+	for _, h := range nodes {
+		state := h.AggregateState()
+		if state == nil {
+			return 0, false
+		}
+		result, ok := state.Results[testMetric]["avg"]
+		if !ok {
+			return 0, false
+		}
+		maxErr = math.Max(maxErr, math.Abs(result-mean)/mean)
+	}
+	return maxErr, true
+}
+
+func TestClusterConvergesToMean(t *testing.T) {
+	tests := []struct {
+		name       string
+		n          int
+		minLatency time.Duration
+		maxLatency time.Duration
+		loss       float64
+		reorder    float64
+	}{
+		{name: "instant", n: 5},
+		{name: "latency", n: 20, minLatency: time.Millisecond, maxLatency: 50 * time.Millisecond},
+		{name: "reordering", n: 20, maxLatency: 100 * time.Millisecond, reorder: 0.2},
+		{name: "loss", n: 20, minLatency: time.Millisecond, maxLatency: 10 * time.Millisecond, loss: 0.05},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quietLogs(t)
+			c := clock.NewFake(time.Unix(0, 0))
+			network := peers.NewMemNetwork(1)
+			network.Clock = c
+			network.MinLatency = tt.minLatency
+			network.MaxLatency = tt.maxLatency
+			network.Loss = tt.loss
+			network.Reorder = tt.reorder
+			nodes := newTestCluster(t, tt.n, network, c)
+			mean := float64(tt.n+1) / 2
+
+			for s := 1; s <= 60; s++ {
+				c.Advance(time.Second)
+				if maxErr, ok := maxError(nodes, mean); ok && maxErr < 1e-9 {
+					return
+				}
+			}
+			maxErr, ok := maxError(nodes, mean)
+			t.Fatalf("no convergence to %g after 60s: max error %g, all nodes with result %t", mean, maxErr, ok)
+		})
+	}
+}
+
+// TestMergedAggregatesFitInAPacket gives every node series of its own, each
+// node's fit in a packet but their union does not.
+func TestMergedAggregatesFitInAPacket(t *testing.T) {
+	quietLogs(t)
+	c := clock.NewFake(time.Unix(0, 0))
+	network := peers.NewMemNetwork(1)
+	network.Clock = c
+	nodes := newTestCluster(t, 10, network, c)
+	for _, h := range nodes {
+		h.Lock.Lock()
+		h.Params.Metrics = []string{AllMetrics}
+		h.Lock.Unlock()
+		samples := make([]Sample, 0, 3)
+		for j := range 3 {
+			samples = append(samples, Sample{Name: fmt.Sprintf("node_%s_metric_%d", h.Params.ID, j), Value: 1})
+		}
+		h.SetSamples(samples)
+		if len(h.Values) != len(samples)+1 {
+			t.Fatalf("node %s kept %d of %d series", h.Params.ID, len(h.Values), len(samples)+1)
+		}
+	}
+	peers.PacketsDroppedLock.Lock()
+	dropped := peers.PacketsDropped["oversized"]
+	peers.PacketsDroppedLock.Unlock()
+
+	c.Advance(30 * time.Second)
+	for _, h := range nodes {
+		state := h.AggregateState()
+		if state == nil || state.Count != len(nodes) {
+			t.Fatalf("node %s has no aggregate of all %d nodes: %+v", h.Params.ID, len(nodes), state)
+		}
+		if len(state.Results) == 0 || len(state.Results) == 4*len(nodes) {
+			t.Fatalf("node %s has %d of %d series", h.Params.ID, len(state.Results), 4*len(nodes))
+		}
+	}
+	peers.PacketsDroppedLock.Lock()
+	defer peers.PacketsDroppedLock.Unlock()
+	if n := peers.PacketsDropped["oversized"] - dropped; n != 0 {
+		t.Fatalf("%d aggregates did not fit in a packet", n)
+	}
+}
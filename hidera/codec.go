@@ -0,0 +1,316 @@
+package hidera
+
+import (
+	"encoding/binary"
+	"errors"
+	"maps"
+	"math"
+	"slices"
+)
+
+// Binary frames are [type][version][payload]. JSON frames are [type][json]
+// and their second byte is always '{', so both can be told apart and nodes
+// decode either format. A cluster is migrated by rolling out the new binary
+// first and switching WIRE_FORMAT to binary once every node can decode it.
+const BINARY_WIRE_VERSION byte = 1
+
+const (
+	JSON_WIRE_FORMAT   = "json"
+	BINARY_WIRE_FORMAT = "binary"
+)
+
+var errShortBuffer = errors.New("message too short")
+
+// EncodeMsg fails only for the JSON format, which cannot encode NaN and
+// infinite values.
+func EncodeMsg(msg Msg, format string) ([]byte, error) {
+	if format == BINARY_WIRE_FORMAT {
+		return MsgToBinary(msg), nil
+	}
+	return MsgToBytes(msg)
+}
+
+func MsgToBinary(msg Msg) []byte {
+	b := []byte{byte(msg.Type())}
+	switch m := msg.(type) {
+	case PingMsg:
+		return b
+	case LocalAggMsg:
+		b = append(b, BINARY_WIRE_VERSION)
+		b = appendString(b, m.TreeID)
+		b = appendMetrics(b, m.Metrics)
+		b = binary.AppendVarint(b, int64(m.Count))
+		b = appendHyperLogLog(b, m.Distinct)
+		b = binary.AppendVarint(b, int64(m.SenderRound))
+	case GlobalAggMsg:
+		b = append(b, BINARY_WIRE_VERSION)
+		b = appendString(b, m.TreeID)
+		b = appendMetrics(b, m.Metrics)
+		b = binary.AppendVarint(b, int64(m.Count))
+		b = appendHyperLogLog(b, m.Distinct)
+		b = binary.AppendVarint(b, int64(m.Level))
+		b = binary.AppendVarint(b, int64(m.ValueRound))
+		b = binary.AppendVarint(b, int64(m.SenderRound))
+	case GlobalAggLazyMsg:
+		b = append(b, BINARY_WIRE_VERSION)
+		b = appendString(b, m.TreeID)
+		b = binary.AppendVarint(b, int64(m.ValueRound))
+		b = binary.AppendVarint(b, int64(m.SenderRound))
+	case LeaveMsg:
+		b = append(b, BINARY_WIRE_VERSION)
+		b = appendString(b, m.TreeID)
+		b = appendString(b, m.Successor)
+		b = binary.AppendVarint(b, int64(m.ValueRound))
+	}
+	return b
+}
+
+func binaryToMsg(msgType int8, payload []byte) (Msg, error) {
+	r := &reader{buf: payload}
+	var msg Msg
+	switch msgType {
+	case LOCAL_AGG_MSG_TYPE:
+		m := &LocalAggMsg{}
+		m.TreeID = r.string()
+		m.Metrics = r.metrics()
+		m.Count = r.int()
+		m.Distinct = r.hyperLogLog()
+		m.SenderRound = r.int()
+		msg = m
+	case GLOBAL_AGG_MSG_TYPE:
+		m := &GlobalAggMsg{}
+		m.TreeID = r.string()
+		m.Metrics = r.metrics()
+		m.Count = r.int()
+		m.Distinct = r.hyperLogLog()
+		m.Level = r.int()
+		m.ValueRound = r.int()
+		m.SenderRound = r.int()
+		msg = m
+	case GLOBAL_AGG_LAZY_MSG_TYPE:
+		m := &GlobalAggLazyMsg{}
+		m.TreeID = r.string()
+		m.ValueRound = r.int()
+		m.SenderRound = r.int()
+		msg = m
+	case LEAVE_MSG_TYPE:
+		m := &LeaveMsg{}
+		m.TreeID = r.string()
+		m.Successor = r.string()
+		m.ValueRound = r.int()
+		msg = m
+	default:
+		return nil, ErrUnknownMsgType
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.buf) > 0 {
+		return nil, errors.New("trailing bytes after message")
+	}
+	return msg, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendFloat(b []byte, f float64) []byte {
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(f))
+}
+
+func appendMetrics(b []byte, metrics map[string]MetricAgg) []byte {
+	b = binary.AppendUvarint(b, uint64(len(metrics)))
+	for _, name := range slices.Sorted(maps.Keys(metrics)) {
+		m := metrics[name]
+		b = appendString(b, name)
+		b = appendFloat(b, m.Value)
+		b = binary.AppendVarint(b, int64(m.Count))
+		b = appendFloat(b, m.Min)
+		b = appendFloat(b, m.Max)
+		b = appendFloat(b, m.M2)
+		b = appendQuantileSketch(b, m.Sketch)
+	}
+	return b
+}
+
+func appendQuantileSketch(b []byte, s *QuantileSketch) []byte {
+	if s == nil {
+		return append(b, 0)
+	}
+	b = append(b, 1)
+	b = appendFloat(b, s.Alpha)
+	b = binary.AppendVarint(b, int64(s.MaxBins))
+	b = binary.AppendUvarint(b, s.Zeros)
+	b = appendBins(b, s.Bins)
+	return appendBins(b, s.NegBins)
+}
+
+// appendBins delta-encodes the sorted bin indexes, neighbouring bins are the
+// common case so the deltas fit in a single byte.
+func appendBins(b []byte, bins map[int]uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(len(bins)))
+	prev := 0
+	for _, i := range slices.Sorted(maps.Keys(bins)) {
+		b = binary.AppendVarint(b, int64(i-prev))
+		b = binary.AppendUvarint(b, bins[i])
+		prev = i
+	}
+	return b
+}
+
+func appendHyperLogLog(b []byte, hll *HyperLogLog) []byte {
+	if hll == nil {
+		return append(b, 0)
+	}
+	b = append(b, 1, hll.Precision)
+	return append(b, hll.Registers...)
+}
+
+// reader decodes binary payloads. The first error is kept and every later
+// read returns a zero value, so decoders check it only once at the end.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.buf = nil
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.buf) {
+		r.fail(errShortBuffer)
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) byte() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.fail(errors.New("invalid varint"))
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *reader) int() int {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf)
+	if n <= 0 || v > math.MaxInt32 || v < math.MinInt32 {
+		r.fail(errors.New("invalid varint"))
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return int(v)
+}
+
+// length reads a count of items that take at least minSize bytes each, and
+// rejects counts the remaining payload cannot hold.
+func (r *reader) length(minSize int) int {
+	n := r.uvarint()
+	if r.err == nil && n > uint64(len(r.buf)/minSize) {
+		r.fail(errShortBuffer)
+		return 0
+	}
+	return int(n)
+}
+
+func (r *reader) float() float64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+func (r *reader) string() string {
+	return string(r.bytes(r.length(1)))
+}
+
+func (r *reader) metrics() map[string]MetricAgg {
+	n := r.length(1)
+	metrics := make(map[string]MetricAgg, n)
+	for range n {
+		name := r.string()
+		m := MetricAgg{}
+		m.Value = r.float()
+		m.Count = r.int()
+		m.Min = r.float()
+		m.Max = r.float()
+		m.M2 = r.float()
+		m.Sketch = r.quantileSketch()
+		if r.err != nil {
+			return nil
+		}
+		metrics[name] = m
+	}
+	return metrics
+}
+
+func (r *reader) quantileSketch() *QuantileSketch {
+	if r.byte() == 0 {
+		return nil
+	}
+	s := &QuantileSketch{}
+	s.Alpha = r.float()
+	s.MaxBins = r.int()
+	s.Zeros = r.uvarint()
+	s.Bins = r.bins()
+	s.NegBins = r.bins()
+	if r.err == nil && (s.Alpha <= 0 || s.Alpha >= 1) {
+		r.fail(errors.New("invalid sketch accuracy"))
+	}
+	return s
+}
+
+func (r *reader) bins() map[int]uint64 {
+	n := r.length(2)
+	bins := make(map[int]uint64, n)
+	i := 0
+	for range n {
+		i += r.int()
+		bins[i] = r.uvarint()
+	}
+	return bins
+}
+
+func (r *reader) hyperLogLog() *HyperLogLog {
+	if r.byte() == 0 {
+		return nil
+	}
+	precision := r.byte()
+	if r.err == nil && (precision < 4 || precision > 16) {
+		r.fail(errors.New("invalid HLL precision"))
+		return nil
+	}
+	registers := r.bytes(1 << precision)
+	if registers == nil {
+		return nil
+	}
+	return &HyperLogLog{Precision: precision, Registers: slices.Clone(registers)}
+}
@@ -0,0 +1,72 @@
+package hidera
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+var wireFormats = []string{JSON_WIRE_FORMAT, BINARY_WIRE_FORMAT}
+
+func BenchmarkEncode(b *testing.B) {
+	for _, msg := range testMsgs() {
+		for _, format := range wireFormats {
+			b.Run(MsgTypeName(msg.Type())+"/"+format, func(b *testing.B) {
+				b.ReportAllocs()
+				for b.Loop() {
+					EncodeMsg(msg, format)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, msg := range testMsgs() {
+		for _, format := range wireFormats {
+			data, err := EncodeMsg(msg, format)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(MsgTypeName(msg.Type())+"/"+format, func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(len(data)))
+				for b.Loop() {
+					if _, err := BytesToMsg(data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	for _, msg := range testMsgs() {
+		for _, format := range wireFormats {
+			data, err := EncodeMsg(msg, format)
+			if err != nil {
+				t.Fatalf("%s/%s: %v", MsgTypeName(msg.Type()), format, err)
+			}
+			decoded, err := BytesToMsg(data)
+			if err != nil {
+				t.Fatalf("%s/%s: %v", MsgTypeName(msg.Type()), format, err)
+			}
+			if got, want := MsgToBinary(deref(decoded)), MsgToBinary(msg); string(got) != string(want) {
+				t.Errorf("%s/%s: decoded %+v, want %+v", MsgTypeName(msg.Type()), format, decoded, msg)
+			}
+		}
+	}
+}
+
+func TestNonFiniteValues(t *testing.T) {
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		msg := LocalAggMsg{TreeID: "1", Metrics: map[string]MetricAgg{"m": NewMetricAgg(f)}, Count: 1}
+		if _, err := MsgToBytes(msg); err == nil {
+			t.Errorf("%g encoded to JSON", f)
+		}
+		if _, err := BytesToMsg(MsgToBinary(msg)); !errors.Is(err, ErrMalformedMsg) {
+			t.Errorf("%g decoded from binary: %v", f, err)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package hidera
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	DecodeErrors     = map[string]int{}
+	DecodeErrorsLock = new(sync.Mutex)
+)
+
+func countDecodeError(err error) {
+	reason := "malformed"
+	switch {
+	case errors.Is(err, ErrEmptyMsg):
+		reason = "empty"
+	case errors.Is(err, ErrUnknownMsgType):
+		reason = "unknown_type"
+	case errors.Is(err, ErrUnknownWireVersion):
+		reason = "unknown_version"
+	}
+	DecodeErrorsLock.Lock()
+	DecodeErrors[reason]++
+	DecodeErrorsLock.Unlock()
+}
@@ -0,0 +1,59 @@
+package hidera
+
+import (
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/tamararankovic/hidera/peers"
+	"github.com/tamararankovic/hidera/sink"
+)
+
+func (h *Hidera) export() {
+	records := make([]sink.Record, 0)
+	ts := strconv.Itoa(int(h.Clock.Now().UnixNano()))
+	h.Lock.Lock()
+	tree := h.FindBestTree()
+	if tree != nil && tree.GlobalAgg != nil {
+		for _, name := range slices.Sorted(maps.Keys(tree.GlobalAgg.Metrics)) {
+			record := sink.NewRecord("value").Add("metric", name).Add("req_ts", "0").Add("rcv_ts", ts)
+			for _, f := range h.AggFuncs {
+				record.Add(f.Name(), formatFloat(f.Result(tree.GlobalAgg.Metrics[name])))
+			}
+			records = append(records, *record)
+		}
+	}
+	if h.Distinct != nil {
+		cardinality := 0.0
+		if tree != nil && tree.GlobalAgg != nil && tree.GlobalAgg.Distinct != nil {
+			cardinality = tree.GlobalAgg.Distinct.Estimate()
+		}
+		record := sink.NewRecord("distinct").Add("req_ts", "0").Add("rcv_ts", ts).Add("value", formatFloat(cardinality))
+		records = append(records, *record)
+	}
+	h.Lock.Unlock()
+	records = append(records, msgCountRecord(ts))
+	for _, record := range records {
+		if err := h.sinks.Write(record); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func msgCountRecord(ts string) sink.Record {
+	peers.MessagesSentLock.Lock()
+	sent := peers.MessagesSent
+	peers.MessagesSentLock.Unlock()
+	peers.MessagesRcvdLock.Lock()
+	rcvd := peers.MessagesRcvd
+	peers.MessagesRcvdLock.Unlock()
+	return *sink.NewRecord("msg_count").
+		Add("ts", ts).
+		Add("sent", strconv.Itoa(sent)).
+		Add("rcvd", strconv.Itoa(rcvd))
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
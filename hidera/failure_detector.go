@@ -0,0 +1,218 @@
+package hidera
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/tamararankovic/hidera/config"
+)
+
+const (
+	ROUNDS_FAILURE_DETECTOR = "rounds"
+	PHI_FAILURE_DETECTOR    = "phi"
+)
+
+// FailureDetector decides from the arrival of messages which peers have
+// failed. Every method gets both the round and the time, so a detector can
+// count in either. The node calls it with its lock held.
+type FailureDetector interface {
+	// Watch starts monitoring a peer that has not been heard from yet, it
+	// is not suspected during a startup grace period.
+	Watch(id string, round int, now time.Time)
+	Heartbeat(id string, round int, now time.Time)
+	// Suspicion is 0 while a peer is surely alive and grows while it is
+	// silent, the scale depends on the detector. It does not change the
+	// detector and is 0 for peers that are not watched.
+	Suspicion(id string, round int, now time.Time) float64
+	// Failed watches a peer that is not watched yet from now on.
+	Failed(id string, round int, now time.Time) bool
+	Remove(id string)
+}
+
+func newFailureDetector(params config.Params) (FailureDetector, error) {
+	switch params.FailureDetector {
+	case "", ROUNDS_FAILURE_DETECTOR:
+		return NewRoundsDetector(params.Rmax, params.Rgrace), nil
+	case PHI_FAILURE_DETECTOR:
+		tagg := time.Duration(params.Tagg) * time.Second
+		return NewPhiDetector(params.PhiThreshold, params.PhiWindow, tagg,
+			seconds(params.PhiMinStdDev), seconds(params.PhiPause), time.Duration(params.Rgrace)*tagg), nil
+	}
+	return nil, fmt.Errorf("unknown failure detector %q", params.FailureDetector)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// RoundsDetector fails a peer that has been silent for more than Rmax
+// rounds. Its suspicion is the number of silent rounds divided by Rmax.
+type RoundsDetector struct {
+	Rmax   int
+	Rgrace int
+	last   map[string]int
+}
+
+func NewRoundsDetector(rmax, rgrace int) *RoundsDetector {
+	return &RoundsDetector{
+		Rmax:   rmax,
+		Rgrace: rgrace,
+		last:   make(map[string]int),
+	}
+}
+
+func (d *RoundsDetector) Watch(id string, round int, now time.Time) {
+	// pretend the peer was heard from so that it fails Rgrace rounds
+	// from now
+	d.last[id] = round + d.Rgrace - d.Rmax
+}
+
+func (d *RoundsDetector) Heartbeat(id string, round int, now time.Time) {
+	d.last[id] = round
+}
+
+func (d *RoundsDetector) Suspicion(id string, round int, now time.Time) float64 {
+	last, ok := d.last[id]
+	if !ok {
+		return 0
+	}
+	return max(0, float64(round-last)/float64(max(d.Rmax, 1)))
+}
+
+func (d *RoundsDetector) Failed(id string, round int, now time.Time) bool {
+	return round-d.lastHeard(id, round, now) > d.Rmax
+}
+
+func (d *RoundsDetector) lastHeard(id string, round int, now time.Time) int {
+	if _, ok := d.last[id]; !ok {
+		d.Watch(id, round, now)
+	}
+	return d.last[id]
+}
+
+func (d *RoundsDetector) Remove(id string) {
+	delete(d.last, id)
+}
+
+// PhiDetector is the phi accrual failure detector of Hayashibara et al.
+// It keeps the last Window inter-arrival times of every peer and its
+// suspicion phi is -log10 of the probability that the next message arrives
+// later than now, assuming normally distributed inter-arrival times. A peer
+// fails once phi exceeds Threshold. The history of a peer starts with
+// intervals around Interval, AcceptablePause is added to the mean to
+// tolerate pauses and MinStdDev bounds the deviation of peers that send very
+// regularly.
+type PhiDetector struct {
+	Threshold       float64
+	Window          int
+	Interval        time.Duration
+	MinStdDev       time.Duration
+	AcceptablePause time.Duration
+	Grace           time.Duration
+	arrivals        map[string]*arrivals
+}
+
+type arrivals struct {
+	last      time.Time
+	heard     bool
+	intervals []float64
+	next      int
+	sum       float64
+	sumSq     float64
+}
+
+func NewPhiDetector(threshold float64, window int, interval, minStdDev, acceptablePause, grace time.Duration) *PhiDetector {
+	return &PhiDetector{
+		Threshold:       threshold,
+		Window:          max(window, 1),
+		Interval:        interval,
+		MinStdDev:       minStdDev,
+		AcceptablePause: acceptablePause,
+		Grace:           grace,
+		arrivals:        make(map[string]*arrivals),
+	}
+}
+
+func (d *PhiDetector) Watch(id string, round int, now time.Time) {
+	// the first message is expected within the grace period
+	d.arrivals[id] = &arrivals{last: now.Add(d.Grace - d.Interval)}
+}
+
+func (d *PhiDetector) Heartbeat(id string, round int, now time.Time) {
+	a := d.arrivals[id]
+	if a == nil || !a.heard {
+		d.arrivals[id] = d.firstArrival(now)
+		return
+	}
+	if interval := now.Sub(a.last).Seconds(); interval > 0 {
+		a.add(interval, d.Window)
+	}
+	a.last = now
+}
+
+// firstArrival starts the history with two intervals around Interval, so
+// that the estimate of the first few intervals is not too confident.
+func (d *PhiDetector) firstArrival(now time.Time) *arrivals {
+	a := &arrivals{last: now, heard: true}
+	interval := d.Interval.Seconds()
+	a.add(interval*3/4, d.Window)
+	a.add(interval*5/4, d.Window)
+	return a
+}
+
+func (a *arrivals) add(interval float64, window int) {
+	if len(a.intervals) < window {
+		a.intervals = append(a.intervals, interval)
+	} else {
+		old := a.intervals[a.next]
+		a.sum -= old
+		a.sumSq -= old * old
+		a.intervals[a.next] = interval
+		a.next = (a.next + 1) % window
+	}
+	a.sum += interval
+	a.sumSq += interval * interval
+}
+
+func (d *PhiDetector) Suspicion(id string, round int, now time.Time) float64 {
+	a := d.arrivals[id]
+	if a == nil {
+		return 0
+	}
+	mean := d.Interval.Seconds()
+	stdDev := 0.0
+	if n := float64(len(a.intervals)); n > 0 {
+		mean = a.sum / n
+		stdDev = math.Sqrt(max(0, a.sumSq/n-mean*mean))
+	}
+	mean += d.AcceptablePause.Seconds()
+	stdDev = max(stdDev, d.MinStdDev.Seconds())
+	return phi(now.Sub(a.last).Seconds(), mean, stdDev)
+}
+
+func (d *PhiDetector) Failed(id string, round int, now time.Time) bool {
+	if d.arrivals[id] == nil {
+		d.Watch(id, round, now)
+	}
+	return d.Suspicion(id, round, now) > d.Threshold
+}
+
+func (d *PhiDetector) Remove(id string) {
+	delete(d.arrivals, id)
+}
+
+// phi uses the logistic approximation of the normal CDF. Above the mean it
+// is computed from the exponent, so that it stays finite for long silences
+// instead of rounding to infinity.
+func phi(elapsed, mean, stdDev float64) float64 {
+	if stdDev <= 0 {
+		stdDev = 1e-3
+	}
+	y := (elapsed - mean) / stdDev
+	x := y * (1.5976 + 0.070566*y*y)
+	if x > 0 {
+		return x/math.Ln10 + math.Log10(1+math.Exp(-x))
+	}
+	return -math.Log10(1 - 1/(1+math.Exp(-x)))
+}
@@ -0,0 +1,102 @@
+package hidera
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hidera/config"
+	"github.com/tamararankovic/hidera/peers"
+)
+
+func TestRoundsDetector(t *testing.T) {
+	d := NewRoundsDetector(3, 10)
+	now := time.Unix(0, 0)
+	if s := d.Suspicion("a", 0, now); s != 0 || len(d.last) != 0 {
+		t.Fatalf("unwatched peer has suspicion %g, %d peers watched", s, len(d.last))
+	}
+
+	d.Watch("a", 0, now)
+	if d.Failed("a", 10, now) || !d.Failed("a", 11, now) {
+		t.Fatal("watched peer not failed right after the grace period")
+	}
+	d.Heartbeat("a", 20, now)
+	if s := d.Suspicion("a", 20, now); s != 0 {
+		t.Fatalf("suspicion %g right after a heartbeat", s)
+	}
+	if s := d.Suspicion("a", 23, now); s != 1 {
+		t.Fatalf("suspicion %g after Rmax silent rounds, want 1", s)
+	}
+	if d.Failed("a", 23, now) || !d.Failed("a", 24, now) {
+		t.Fatal("peer not failed after more than Rmax silent rounds")
+	}
+
+	d.Remove("a")
+	if s := d.Suspicion("a", 30, now); s != 0 {
+		t.Fatalf("removed peer has suspicion %g", s)
+	}
+	if d.Failed("b", 5, now) || len(d.last) != 1 {
+		t.Fatal("Failed does not watch an unknown peer")
+	}
+}
+
+func TestPhiDetector(t *testing.T) {
+	d := NewPhiDetector(8, 100, time.Second, 100*time.Millisecond, 0, 10*time.Second)
+	start := time.Unix(0, 0)
+	if s := d.Suspicion("a", 0, start); s != 0 || len(d.arrivals) != 0 {
+		t.Fatalf("unwatched peer has suspicion %g, %d peers watched", s, len(d.arrivals))
+	}
+
+	d.Watch("a", 0, start)
+	if d.Failed("a", 0, start.Add(5*time.Second)) {
+		t.Fatal("peer failed during the grace period")
+	}
+	if !d.Failed("a", 0, start.Add(20*time.Second)) {
+		t.Fatal("peer not failed long after the grace period")
+	}
+
+	now := start
+	for range 30 {
+		now = now.Add(time.Second)
+		d.Heartbeat("a", 0, now)
+	}
+	low := d.Suspicion("a", 0, now.Add(time.Second))
+	high := d.Suspicion("a", 0, now.Add(3*time.Second))
+	if low >= 1 || high <= low {
+		t.Fatalf("suspicion %g after one interval and %g after three", low, high)
+	}
+	if d.Failed("a", 0, now.Add(time.Second)) || !d.Failed("a", 0, now.Add(10*time.Second)) {
+		t.Fatal("regular peer not failed after a long silence")
+	}
+
+	d.Remove("a")
+	if s := d.Suspicion("a", 0, now); s != 0 {
+		t.Fatalf("removed peer has suspicion %g", s)
+	}
+	d.Failed("b", 0, now)
+	if len(d.arrivals) != 1 {
+		t.Fatal("Failed does not watch an unknown peer")
+	}
+}
+
+func TestPeerStatesDoesNotWatchPeers(t *testing.T) {
+	quietLogs(t)
+	h, err := New(
+		WithParams(testParams("1")),
+		WithPeerConfig(config.Config{PeersIDs: []string{"2"}, PeersAddrs: []string{testAddr(2)}}),
+		WithTransport(peers.NewMemNetwork(1).Transport(testAddr(1))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Peers.Close()
+	detector := h.Detector.(*RoundsDetector)
+	detector.Remove("2")
+
+	states := h.PeerStates()
+	if len(states) != 1 || states[0].Suspicion != 0 {
+		t.Fatalf("got %+v, want peer 2 without suspicion", states)
+	}
+	if len(detector.last) != 0 {
+		t.Fatalf("PeerStates made the detector watch %d peers", len(detector.last))
+	}
+}
@@ -0,0 +1,36 @@
+package hidera
+
+import (
+	"strconv"
+	"strings"
+)
+
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// SeriesKey identifies the group a sample is aggregated in. Only the labels
+// in groupBy are kept, in the order they are configured, so that every node
+// derives the same key for the same group, e.g. app_memory_usage_bytes{region="eu"}.
+func SeriesKey(name string, labels map[string]string, groupBy []string) string {
+	parts := make([]string, 0, len(groupBy))
+	for _, label := range groupBy {
+		value, ok := labels[label]
+		if !ok {
+			continue
+		}
+		parts = append(parts, label+"="+strconv.Quote(value))
+	}
+	if len(parts) == 0 {
+		return name
+	}
+	return name + "{" + strings.Join(parts, ",") + "}"
+}
+
+// MetricName strips the group labels from a series key.
+func MetricName(key string) string {
+	name, _, _ := strings.Cut(key, "{")
+	return name
+}
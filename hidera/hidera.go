@@ -1,6 +1,8 @@
 package hidera
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"maps"
 	"math"
@@ -10,13 +12,17 @@ import (
 	"sync"
 	"time"
 
+	"github.com/tamararankovic/hidera/clock"
 	"github.com/tamararankovic/hidera/config"
 	"github.com/tamararankovic/hidera/peers"
+	"github.com/tamararankovic/hidera/sink"
 )
 
 type Hidera struct {
 	Params        config.Params
-	Value         float64
+	Values        map[string]float64
+	AggFuncs      []AggFunc
+	Distinct      *HyperLogLog
 	Peers         *peers.Peers
 	Round         int
 	CountEstimate int
@@ -24,18 +30,81 @@ type Hidera struct {
 	Trees         map[string]*Tree
 	IsRoot        bool
 	Lock          *sync.Mutex
-	electing      bool
+	// Clock drives rounds, elections and pings, it defaults to the real
+	// clock.
+	Clock clock.Clock
+	// Rand is the source of the randomness in root elections.
+	Rand *rand.Rand
+	// Detector decides when silent peers have failed, it is selected by
+	// FAILURE_DETECTOR.
+	Detector    FailureDetector
+	electing    bool
+	source      ValueSource
+	sinks       sink.Sink
+	ownsPeers   bool
+	ctx         context.Context
+	cancel      context.CancelFunc
+	started     bool
+	stopped     bool
+	subscribers []chan AggregateState
+	peerEvents  []chan PeerEvent
+	published   *AggregateState
+	// maxMsgSize is the largest aggregate message that fits in a packet.
+	maxMsgSize int
 }
 
 func NewHidera(params config.Params, peers *peers.Peers) *Hidera {
+	h, err := newHidera(params, peers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return h
+}
+
+func newHidera(params config.Params, peers *peers.Peers) (*Hidera, error) {
 	log.Printf("[INIT] NewHidera created with ID=%s", params.ID)
 	val, err := strconv.Atoi(params.ID)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	aggFuncs, err := GetAggFuncs(params.AggFuncs)
+	if err != nil {
+		return nil, err
+	}
+	if params.WireFormat != JSON_WIRE_FORMAT && params.WireFormat != BINARY_WIRE_FORMAT {
+		return nil, fmt.Errorf("invalid wire format %q", params.WireFormat)
+	}
+	if needsSketch(aggFuncs) {
+		if params.SketchAlpha <= 0 || params.SketchAlpha >= 1 {
+			return nil, fmt.Errorf("invalid sketch accuracy %g, must be between 0 and 1", params.SketchAlpha)
+		}
+		if params.SketchMaxBins <= 0 {
+			return nil, fmt.Errorf("invalid sketch size %d, must be positive", params.SketchMaxBins)
+		}
+	}
+	var distinct *HyperLogLog
+	if params.Distinct {
+		if params.HllPrecision < 4 || params.HllPrecision > 16 {
+			return nil, fmt.Errorf("invalid HLL precision %d, must be between 4 and 16", params.HllPrecision)
+		}
+		distinct = NewHyperLogLog(uint8(params.HllPrecision))
+	}
+	detector, err := newFailureDetector(params)
+	if err != nil {
+		return nil, err
 	}
-	return &Hidera{
+	values := make(map[string]float64)
+	for _, name := range params.Metrics {
+		if name == AllMetrics {
+			continue
+		}
+		values[name] = float64(val)
+	}
+	h := &Hidera{
 		Params:        params,
-		Value:         float64(val),
+		Values:        values,
+		AggFuncs:      aggFuncs,
+		Distinct:      distinct,
 		Peers:         peers,
 		Round:         0,
 		CountEstimate: 1,
@@ -43,152 +112,360 @@ func NewHidera(params config.Params, peers *peers.Peers) *Hidera {
 		Trees:         make(map[string]*Tree),
 		IsRoot:        false,
 		Lock:          new(sync.Mutex),
+		Clock:         clock.Real{},
+		Rand:          rand.New(rand.NewSource(time.Now().UnixNano())),
+		Detector:      detector,
 		electing:      false,
+		ctx:           context.Background(),
+		maxMsgSize:    peers.MaxMessageSize(),
+	}
+	if err := h.checkMsgSize(); err != nil {
+		return nil, err
 	}
+	return h, nil
 }
 
 func (h *Hidera) Run() {
 	log.Printf("[START] Node %s starting Run()", h.Params.ID)
 
-	go h.handlePeerAdded()
+	h.Lock.Lock()
+	for _, p := range h.Peers.GetPeers() {
+		h.Detector.Watch(p.GetID(), h.Round, h.Clock.Now())
+	}
+	h.Lock.Unlock()
+
+	go h.handleViewChanges()
 	go h.handleMessages()
-	go h.sendPingToFailed()
 
-	go func() {
-		ticker := time.NewTicker(time.Duration(h.Params.Tagg) * time.Second)
-		for range ticker.C {
+	tagg := time.Duration(h.Params.Tagg) * time.Second
+	var round, ping func()
+	round = func() {
+		if h.isStopped() {
+			return
+		}
+		h.Clock.AfterFunc(tagg, round)
+		h.pollSource()
+		h.ExecuteRound()
+	}
+	ping = func() {
+		if h.isStopped() {
+			return
+		}
+		h.Clock.AfterFunc(10*tagg, ping)
+		h.PingFailedPeers()
+	}
+	h.Clock.AfterFunc(tagg, round)
+	h.Clock.AfterFunc(10*tagg, ping)
+}
 
-			h.Lock.Lock()
-			log.Printf("[ROUND] Node %s entering round %d", h.Params.ID, h.Round+1)
+// ExecuteRound runs one aggregation round, Run calls it every Tagg seconds.
+func (h *Hidera) ExecuteRound() {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	log.Printf("[ROUND] Node %s entering round %d", h.Params.ID, h.Round+1)
 
-			h.Round++
+	h.Round++
 
-			h.removeInactiveTrees()
-			h.removeFailedPeers()
+	h.removeInactiveTrees()
+	h.suspectFailedPeers()
+	h.applyViewChange(h.Peers.Maintain())
 
-			bestTree := h.FindBestTree()
-			if bestTree != nil {
-				log.Printf("[BEST TREE] Node %s best tree = %s", h.Params.ID, bestTree.ID)
-			}
+	bestTree := h.FindBestTree()
+	if bestTree != nil {
+		log.Printf("[BEST TREE] Node %s best tree = %s", h.Params.ID, bestTree.ID)
+	}
 
-			for id, tree := range h.Trees {
-				isBest := bestTree != nil && bestTree.ID == id
-				log.Printf("[EXEC ROUND] Node %s exec tree %s (isBest=%t)", h.Params.ID, id, isBest)
+	for id, tree := range h.Trees {
+		isBest := bestTree != nil && bestTree.ID == id
+		log.Printf("[EXEC ROUND] Node %s exec tree %s (isBest=%t)", h.Params.ID, id, isBest)
 
-				tree.executeRound(Aggregate{
-					Value: h.Value,
-					Count: 1,
-					Round: h.Round,
-				}, isBest)
+		tree.executeRound(h.localAggregate(), isBest)
 
-				if isBest || !tree.IsRoot {
-					continue
-				}
+		if isBest || !tree.IsRoot {
+			continue
+		}
 
-				log.Printf("[REMOVE ROOT] Node %s removing tree %s because it is not best", h.Params.ID, id)
-				delete(h.Trees, id)
-				h.IsRoot = false
-			}
+		log.Printf("[REMOVE ROOT] Node %s removing tree %s because it is not best", h.Params.ID, id)
+		delete(h.Trees, id)
+		h.IsRoot = false
+	}
 
-			if len(h.Trees) == 0 {
-				h.sendPing()
-				// wait some time to see if messages start arriving
-				if !h.electing && h.Round > 5 {
-					log.Printf("[NO TREES] Node %s tries to elect itself as root", h.Params.ID)
-					h.electing = true
-					h.tryElectSelfAsRoot()
-				}
-			} else {
-				h.computeCount()
-				log.Printf("[COUNT] Node %s CountEstimate updated to %d", h.Params.ID, h.CountEstimate)
-			}
+	if len(h.Trees) == 0 {
+		h.sendPing()
+		// wait some time to see if messages start arriving
+		if !h.electing && h.Round > 5 {
+			log.Printf("[NO TREES] Node %s tries to elect itself as root", h.Params.ID)
+			h.electing = true
+			h.tryElectSelfAsRoot()
+		}
+	} else {
+		h.computeCount()
+		log.Printf("[COUNT] Node %s CountEstimate updated to %d", h.Params.ID, h.CountEstimate)
+	}
 
-			h.Lock.Unlock()
+	h.publish()
+}
+
+func (h *Hidera) localAggregate() Aggregate {
+	agg := NewAggregate(h.Values, h.Round)
+	if needsSketch(h.AggFuncs) {
+		for name, m := range agg.Metrics {
+			m.Sketch = NewQuantileSketch(h.Params.SketchAlpha, h.Params.SketchMaxBins)
+			m.Sketch.Add(h.Values[name])
+			agg.Metrics[name] = m
+		}
+	}
+	if h.Distinct != nil {
+		agg.Distinct = h.Distinct
+	}
+	return agg
+}
+
+// AllMetrics in Params.Metrics accepts every metric that is set.
+const AllMetrics = "*"
+
+func (h *Hidera) IsTracked(name string) bool {
+	return slices.Contains(h.Params.Metrics, AllMetrics) || slices.Contains(h.Params.Metrics, name)
+}
+
+func (h *Hidera) SetValue(name string, value float64) {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	if !h.IsTracked(name) {
+		return
+	}
+	// merged into the aggregates of all nodes, they would never recover
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		log.Printf("[WARN] Node %s ignoring non-finite value %g of %s", h.Params.ID, value, name)
+		return
+	}
+	_, known := h.Values[name]
+	h.Values[name] = value
+	if !known {
+		h.capSeries()
+	}
+}
+
+// SetSamples replaces the local values of every metric present in samples.
+// Samples of a metric that fall into the same group are summed, like a
+// Prometheus sum by (GroupBy), before the groups are aggregated across nodes.
+func (h *Hidera) SetSamples(samples []Sample) {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	names := make(map[string]struct{})
+	values := make(map[string]float64)
+	for _, s := range samples {
+		if !h.IsTracked(s.Name) {
+			continue
 		}
-	}()
+		names[s.Name] = struct{}{}
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			log.Printf("[WARN] Node %s ignoring non-finite sample %g of %s", h.Params.ID, s.Value, s.Name)
+			continue
+		}
+		values[SeriesKey(s.Name, s.Labels, h.Params.GroupBy)] += s.Value
+	}
+	maps.DeleteFunc(h.Values, func(key string, _ float64) bool {
+		_, ok := names[MetricName(key)]
+		return ok
+	})
+	maps.Copy(h.Values, values)
+	h.capSeries()
+}
+
+func (h *Hidera) AddDistinct(items []string) {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	if h.Distinct == nil {
+		return
+	}
+	for _, item := range items {
+		h.Distinct.Add(item)
+	}
 }
 
 func (h *Hidera) handleMessages() {
 	log.Printf("[MSG LOOP] Node %s starting handleMessages()", h.Params.ID)
 
 	for msgRcvd := range h.Peers.Messages {
-		msgAny := BytesToMsg(msgRcvd.MsgBytes)
-		if msgAny == nil {
-			log.Printf("[WARN] Node %s received nil/invalid message", h.Params.ID)
-			continue
-		}
+		h.HandleMessage(msgRcvd)
+	}
+}
 
-		senderID := msgRcvd.Sender.GetID()
-		h.LastMsg[senderID] = h.Round
-		log.Printf("[MSG RECEIVED] Node %s got %T from %s at round %d",
-			h.Params.ID, msgAny, senderID, h.Round)
+func (h *Hidera) HandleMessage(msgRcvd peers.MsgReceived) {
+	msgAny, err := BytesToMsg(msgRcvd.MsgBytes)
+	if err != nil {
+		countDecodeError(err)
+		log.Printf("[WARN] Node %s rejected message from %s: %v", h.Params.ID, msgRcvd.Sender.GetID(), err)
+		return
+	}
 
-		switch msgAny.Type() {
-		case LOCAL_AGG_MSG_TYPE:
-			msg := msgAny.(*LocalAggMsg)
-			h.Lock.Lock()
-			log.Printf("[LOCAL_AGG] Node %s tree=%s sender=%s", h.Params.ID, msg.TreeID, senderID)
+	senderID := msgRcvd.Sender.GetID()
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	// the first message of a round is the heartbeat, the rest of the
+	// round's messages follow it closely and would skew the intervals
+	if last, ok := h.LastMsg[senderID]; !ok || last != h.Round {
+		h.Detector.Heartbeat(senderID, h.Round, h.Clock.Now())
+	}
+	h.LastMsg[senderID] = h.Round
+	log.Printf("[MSG RECEIVED] Node %s got %T from %s at round %d",
+		h.Params.ID, msgAny, senderID, h.Round)
 
-			tree := h.getOrCreateTree(msg.TreeID, -1)
-			bestTree := h.FindBestTree()
+	switch msgAny.Type() {
+	case LOCAL_AGG_MSG_TYPE:
+		msg := msgAny.(*LocalAggMsg)
+		log.Printf("[LOCAL_AGG] Node %s tree=%s sender=%s", h.Params.ID, msg.TreeID, senderID)
 
-			if tree == nil || (bestTree != nil && bestTree.ID != tree.ID) {
-				log.Printf("[LOCAL_AGG] Node %s dropped (not best tree)", h.Params.ID)
-				h.Lock.Unlock()
-				continue
-			}
+		tree := h.getOrCreateTree(msg.TreeID, -1)
+		bestTree := h.FindBestTree()
 
-			tree.onLocalAggMsg(*msg, msgRcvd.Sender)
-			h.Lock.Unlock()
+		if tree == nil || (bestTree != nil && bestTree.ID != tree.ID) {
+			log.Printf("[LOCAL_AGG] Node %s dropped (not best tree)", h.Params.ID)
+			return
+		}
 
-		case GLOBAL_AGG_MSG_TYPE:
-			msg := msgAny.(*GlobalAggMsg)
-			h.Lock.Lock()
-			log.Printf("[GLOBAL_AGG] Node %s tree=%s sender=%s", h.Params.ID, msg.TreeID, senderID)
+		tree.onLocalAggMsg(*msg, msgRcvd.Sender)
 
-			tree := h.getOrCreateTree(msg.TreeID, msg.ValueRound)
-			bestTree := h.FindBestTree()
+	case GLOBAL_AGG_MSG_TYPE:
+		msg := msgAny.(*GlobalAggMsg)
+		log.Printf("[GLOBAL_AGG] Node %s tree=%s sender=%s", h.Params.ID, msg.TreeID, senderID)
 
-			if tree == nil || (bestTree != nil && bestTree.ID != tree.ID) {
-				log.Printf("[GLOBAL_AGG] Node %s dropped (not best tree)", h.Params.ID)
-				h.Lock.Unlock()
-				continue
-			}
+		tree := h.getOrCreateTree(msg.TreeID, msg.ValueRound)
+		bestTree := h.FindBestTree()
 
-			tree.onGlobalAggMsg(*msg, msgRcvd.Sender, h.Round)
-			h.Lock.Unlock()
+		if tree == nil || (bestTree != nil && bestTree.ID != tree.ID) {
+			log.Printf("[GLOBAL_AGG] Node %s dropped (not best tree)", h.Params.ID)
+			return
+		}
 
-		case GLOBAL_AGG_LAZY_MSG_TYPE:
-			msg := msgAny.(*GlobalAggLazyMsg)
-			h.Lock.Lock()
-			log.Printf("[GLOBAL_LAZY] Node %s tree=%s sender=%s", h.Params.ID, msg.TreeID, senderID)
+		tree.onGlobalAggMsg(*msg, msgRcvd.Sender, h.Round)
 
-			tree := h.getOrCreateTree(msg.TreeID, msg.ValueRound)
-			bestTree := h.FindBestTree()
+	case GLOBAL_AGG_LAZY_MSG_TYPE:
+		msg := msgAny.(*GlobalAggLazyMsg)
+		log.Printf("[GLOBAL_LAZY] Node %s tree=%s sender=%s", h.Params.ID, msg.TreeID, senderID)
 
-			if tree == nil || (bestTree != nil && bestTree.ID != tree.ID) {
-				log.Printf("[GLOBAL_LAZY] Node %s dropped (not best tree)", h.Params.ID)
-				h.Lock.Unlock()
-				continue
-			}
+		tree := h.getOrCreateTree(msg.TreeID, msg.ValueRound)
+		bestTree := h.FindBestTree()
 
-			tree.onGlobalLazyAggMsg(*msg, msgRcvd.Sender, h.Round)
-			h.Lock.Unlock()
+		if tree == nil || (bestTree != nil && bestTree.ID != tree.ID) {
+			log.Printf("[GLOBAL_LAZY] Node %s dropped (not best tree)", h.Params.ID)
+			return
 		}
+
+		tree.onGlobalLazyAggMsg(*msg, msgRcvd.Sender, h.Round)
+
+	case LEAVE_MSG_TYPE:
+		msg := msgAny.(*LeaveMsg)
+		log.Printf("[LEAVE] Node %s peer %s is leaving", h.Params.ID, senderID)
+		h.onLeave(*msg, msgRcvd.Sender)
+	}
+}
+
+func (h *Hidera) handleViewChanges() {
+	log.Printf("[VIEW_LOOP] Node %s listening for view changes", h.Params.ID)
+
+	for change := range h.Peers.ViewChanges {
+		h.HandleViewChange(change)
+	}
+}
+
+// HandlePacket passes a packet through the peer layer and handles what it
+// yields, for transports that deliver packets themselves instead of through
+// the listen loop of Peers.
+func (h *Hidera) HandlePacket(packet peers.Packet) {
+	msg, change, ok := h.Peers.Receive(packet)
+	if !change.Empty() {
+		h.HandleViewChange(change)
+	}
+	if ok {
+		h.HandleMessage(msg)
+	}
+}
+
+// HandleViewChange applies a change of the active view reported by the
+// peer layer.
+func (h *Hidera) HandleViewChange(change peers.ViewChange) {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	h.applyViewChange(change)
+}
+
+// AddPeer adds a peer to the active view at runtime.
+func (h *Hidera) AddPeer(id, addr string) {
+	h.HandleViewChange(h.Peers.AddPeer(id, addr))
+}
+
+// RemovePeer removes a peer from the active view at runtime, the trees
+// forget it like a failed peer.
+func (h *Hidera) RemovePeer(id string) {
+	h.HandleViewChange(h.Peers.RemovePeer(id))
+}
+
+func (h *Hidera) peerAdded(peer peers.Peer) {
+	log.Printf("[PEER_ADDED] Node %s new peer=%s", h.Params.ID, peer.GetID())
+
+	// the peer has just been heard from by the peer layer
+	h.LastMsg[peer.GetID()] = h.Round
+	h.Detector.Heartbeat(peer.GetID(), h.Round, h.Clock.Now())
+	for _, tree := range h.Trees {
+		tree.addNewChild(peer)
 	}
 }
 
-func (h *Hidera) handlePeerAdded() {
-	log.Printf("[PEER_ADDED_LOOP] Node %s listening for PeerAdded", h.Params.ID)
+func (h *Hidera) peerRemoved(peer peers.Peer) {
+	log.Printf("[PEER_REMOVED] Node %s removed peer=%s", h.Params.ID, peer.GetID())
+
+	h.forgetPeer(peer)
+	h.reparent()
+}
 
-	for peer := range h.Peers.PeerAdded {
-		log.Printf("[PEER_ADDED] Node %s new peer=%s", h.Params.ID, peer.GetID())
+func (h *Hidera) peerAlive(peer peers.Peer) {
+	log.Printf("[PEER_ALIVE] Node %s suspected peer=%s is alive", h.Params.ID, peer.GetID())
 
-		h.Lock.Lock()
+	// a peer that answers the probes but is still silent has left its tree
+	// relationships with this node, so it starts over as a new child
+	if h.Detector.Failed(peer.GetID(), h.Round, h.Clock.Now()) {
+		h.forgetPeer(peer)
 		for _, tree := range h.Trees {
 			tree.addNewChild(peer)
 		}
-		h.Lock.Unlock()
+	}
+	h.LastMsg[peer.GetID()] = h.Round
+	h.Detector.Heartbeat(peer.GetID(), h.Round, h.Clock.Now())
+	h.emitPeerEvent(PEER_ALIVE, peer)
+}
+
+func (h *Hidera) peerFailed(peer peers.Peer) {
+	log.Printf("[PEER_FAIL] Node %s peer failed=%s", h.Params.ID, peer.GetID())
+
+	h.forgetPeer(peer)
+	h.emitPeerEvent(PEER_FAILED, peer)
+}
+
+func (h *Hidera) peerMoved(peer peers.Peer) {
+	log.Printf("[PEER_MOVED] Node %s peer=%s moved to %s", h.Params.ID, peer.GetID(), peer.GetAddr())
+
+	for _, tree := range h.Trees {
+		tree.replacePeer(peer)
+	}
+}
+
+func (h *Hidera) applyViewChange(change peers.ViewChange) {
+	for _, peer := range change.Removed {
+		h.peerRemoved(peer)
+	}
+	for _, peer := range change.Failed {
+		h.peerFailed(peer)
+	}
+	for _, peer := range change.Added {
+		h.peerAdded(peer)
+	}
+	for _, peer := range change.Alive {
+		h.peerAlive(peer)
+	}
+	for _, peer := range change.Moved {
+		h.peerMoved(peer)
 	}
 }
 
@@ -211,64 +488,140 @@ func (h *Hidera) removeInactiveTrees() {
 	}
 }
 
-func (h *Hidera) removeFailedPeers() {
+// suspectFailedPeers hands the peers that the failure detector considers
+// failed to the peer layer, which probes them before failing them.
+func (h *Hidera) suspectFailedPeers() {
+	now := h.Clock.Now()
 	for _, p := range h.Peers.GetPeers() {
-		if h.Round-h.LastMsg[p.GetID()] <= h.Params.Rmax {
+		if p.IsSuspect() || !h.Detector.Failed(p.GetID(), h.Round, now) {
 			continue
 		}
-		// sequential statup of nodes
-		if h.Round < 10 {
-			continue
+		if _, ok := h.Peers.Suspect(p.GetID()); ok {
+			log.Printf("[PEER_SUSPECT] Node %s suspects peer=%s", h.Params.ID, p.GetID())
+			h.emitPeerEvent(PEER_SUSPECTED, p)
 		}
-		log.Printf("[PEER_FAIL] Node %s peer failed=%s", h.Params.ID, p.GetID())
+	}
+}
 
-		h.Peers.PeerFailed(p.GetID())
-		delete(h.LastMsg, p.GetID())
+// dropPeer marks the peer as failed and removes it from every tree.
+func (h *Hidera) dropPeer(p peers.Peer) {
+	h.Peers.PeerFailed(p.GetID())
+	h.forgetPeer(p)
+}
 
-		for _, tree := range h.Trees {
-			tree.removeChild(p)
-			tree.removeLazy(p)
+func (h *Hidera) forgetPeer(p peers.Peer) {
+	delete(h.LastMsg, p.GetID())
+	h.Detector.Remove(p.GetID())
 
-			if tree.Parent != nil && tree.Parent.GetID() == p.GetID() {
-				log.Printf("[REMOVE_PARENT] Node %s tree %s lost parent %s",
-					h.Params.ID, tree.ID, p.GetID())
-				tree.Parent = nil
-			}
+	for _, tree := range h.Trees {
+		tree.removeChild(p)
+		tree.removeLazy(p)
+
+		if tree.Parent != nil && tree.Parent.GetID() == p.GetID() {
+			log.Printf("[REMOVE_PARENT] Node %s tree %s lost parent %s",
+				h.Params.ID, tree.ID, p.GetID())
+			tree.Parent = nil
+		}
+
+		delete(tree.LastRound, p.GetID())
+		delete(tree.LocalAggs, p.GetID())
+	}
+}
+
+func (h *Hidera) onLeave(msg LeaveMsg, sender peers.Peer) {
+	h.dropPeer(sender)
+
+	tree := h.Trees[msg.TreeID]
+	if tree != nil && msg.Successor == h.Params.ID {
+		log.Printf("[TAKEOVER] Node %s takes over tree %s from %s", h.Params.ID, tree.ID, sender.GetID())
+		tree.IsRoot = true
+		tree.Level = 0
+		tree.LastGlobalRound = h.Round
+		tree.ValueRoundOffset = max(0, msg.ValueRound-h.Round)
+		h.IsRoot = true
+		return
+	}
+
+	h.reparent()
+}
 
-			delete(tree.LastRound, p.GetID())
-			delete(tree.LocalAggs, p.GetID())
+// reparent looks for a new parent in the trees that lost theirs.
+func (h *Hidera) reparent() {
+	for _, tree := range h.Trees {
+		if tree.Parent == nil {
+			tree.findBetterParent()
 		}
 	}
 }
 
+// leave tells the peers that the node is shutting down. A root hands its
+// tree over to the child with the highest ID that sends it local aggregates.
+func (h *Hidera) leave() {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	msg := LeaveMsg{}
+	for _, tree := range h.Trees {
+		if !tree.IsRoot {
+			continue
+		}
+		for _, child := range tree.Children {
+			if _, ok := tree.LocalAggs[child.GetID()]; ok && child.GetID() > msg.Successor {
+				msg.Successor = child.GetID()
+			}
+		}
+		if msg.Successor == "" {
+			continue
+		}
+		msg.TreeID = tree.ID
+		if tree.GlobalAgg != nil {
+			msg.ValueRound = tree.GlobalAgg.Round
+		}
+	}
+	log.Printf("[LEAVE] Node %s leaving, successor=%q", h.Params.ID, msg.Successor)
+	data, err := EncodeMsg(msg, h.Params.WireFormat)
+	if err != nil {
+		log.Printf("[ERROR] Node %s cannot encode the leave message: %v", h.Params.ID, err)
+		return
+	}
+	for _, p := range h.Peers.GetPeers() {
+		p.Send(data)
+	}
+}
+
 func (h *Hidera) tryElectSelfAsRoot() {
 	log.Printf("[ELECTION_START] Node %s begins election loop", h.Params.ID)
 
-	go func() {
-		for len(slices.Collect(maps.Keys(h.Trees))) == 0 {
+	h.Clock.AfterFunc(0, h.electionStep)
+}
 
-			h.Lock.Lock()
-			num := rand.Float64()
+// electionStep makes one election attempt and schedules the next one for
+// as long as the node is not part of any tree.
+func (h *Hidera) electionStep() {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	if h.stopped {
+		return
+	}
+	if len(h.Trees) > 0 {
+		h.electing = false
+		return
+	}
 
-			log.Printf("[ELECTION] Node %s rand=%f threshold=%f", h.Params.ID, num,
-				1/math.Max(float64(h.CountEstimate), 1))
+	num := h.Rand.Float64()
 
-			if num <= (1 / math.Max(float64(h.CountEstimate), 1)) {
-				tree := h.getOrCreateTree(h.Params.ID, h.Round)
-				tree.LastGlobalRound = h.Round
-				tree.IsRoot = true
-				h.IsRoot = true
+	log.Printf("[ELECTION] Node %s rand=%f threshold=%f", h.Params.ID, num,
+		1/math.Max(float64(h.CountEstimate), 1))
 
-				log.Printf("[BECAME_ROOT] Node %s became root of tree %s", h.Params.ID, tree.ID)
-			}
+	if num <= (1 / math.Max(float64(h.CountEstimate), 1)) {
+		tree := h.getOrCreateTree(h.Params.ID, h.Round)
+		tree.LastGlobalRound = h.Round
+		tree.IsRoot = true
+		h.IsRoot = true
 
-			h.Lock.Unlock()
-			time.Sleep(time.Duration(h.Params.Telect) * time.Second)
-		}
-		h.Lock.Lock()
-		h.electing = false
-		h.Lock.Unlock()
-	}()
+		log.Printf("[BECAME_ROOT] Node %s became root of tree %s", h.Params.ID, tree.ID)
+	}
+
+	h.Clock.AfterFunc(time.Duration(h.Params.Telect)*time.Second, h.electionStep)
 }
 
 func (h *Hidera) computeCount() {
@@ -303,6 +656,7 @@ func (h *Hidera) getOrCreateTree(id string, round int) *Tree {
 	if tree == nil {
 		log.Printf("[TREE_CREATE] Node %s creating tree %s (round=%d)", h.Params.ID, id, round)
 		tree = NewTree(h.Params, id, round, h.Peers.GetPeers())
+		tree.MaxMsgSize = h.maxMsgSize
 		h.Trees[id] = tree
 		return tree
 	}
@@ -322,14 +676,14 @@ func (h *Hidera) sendPing() {
 	}
 }
 
-func (h *Hidera) sendPingToFailed() {
+// PingFailedPeers lets failed peers know about this node, so that they can
+// be recovered once they answer.
+func (h *Hidera) PingFailedPeers() {
 	msg := []byte{byte(PING_MSG_TYPE)}
-	for range time.NewTicker(10 * time.Duration(h.Params.Tagg) * time.Second).C {
-		h.Lock.Lock()
-		failed := h.Peers.GetFailedPeers()
-		h.Lock.Unlock()
-		for _, peer := range failed {
-			peer.Send(msg)
-		}
+	h.Lock.Lock()
+	failed := h.Peers.GetFailedPeers()
+	h.Lock.Unlock()
+	for _, peer := range failed {
+		peer.Send(msg)
 	}
 }
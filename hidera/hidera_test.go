@@ -0,0 +1,247 @@
+package hidera
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hidera/clock"
+	"github.com/tamararankovic/hidera/config"
+	"github.com/tamararankovic/hidera/peers"
+)
+
+func round(h *Hidera) int {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	return h.Round
+}
+
+// TestRoundsFollowFakeClock steps the rounds of two nodes through a fake
+// clock, ExecuteRound runs exactly once every Tagg.
+func TestRoundsFollowFakeClock(t *testing.T) {
+	quietLogs(t)
+	c := clock.NewFake(time.Unix(0, 0))
+	network := peers.NewMemNetwork(1)
+	network.Clock = c
+	nodes := newTestCluster(t, 2, network, c)
+	tagg := time.Duration(nodes[0].Params.Tagg) * time.Second
+
+	c.Advance(tagg - time.Millisecond)
+	for _, h := range nodes {
+		if r := round(h); r != 0 {
+			t.Fatalf("node %s at round %d before Tagg passed", h.Params.ID, r)
+		}
+	}
+	for want := 1; want <= 10; want++ {
+		c.Advance(tagg)
+		for _, h := range nodes {
+			if r := round(h); r != want {
+				t.Fatalf("node %s at round %d after %d rounds", h.Params.ID, r, want)
+			}
+		}
+	}
+	for _, h := range nodes {
+		state := h.AggregateState()
+		if state == nil || state.TreeID != "2" || state.Results[testMetric]["avg"] != 1.5 {
+			t.Fatalf("node %s has state %+v, want the average 1.5 of tree 2", h.Params.ID, state)
+		}
+	}
+}
+
+func newTestNode(t *testing.T, params config.Params) (*Hidera, error) {
+	t.Helper()
+	h, err := New(
+		WithParams(params),
+		WithPeerConfig(config.Config{NodeID: params.ID}),
+		WithTransport(peers.NewMemNetwork(1).Transport("node_1:8000")),
+	)
+	if err == nil {
+		t.Cleanup(func() { h.Peers.Close() })
+	}
+	return h, err
+}
+
+func TestNewRejectsAggregatesLargerThanAPacket(t *testing.T) {
+	quietLogs(t)
+	if _, err := newTestNode(t, testParams("1")); err != nil {
+		t.Fatalf("default params rejected: %v", err)
+	}
+	tests := []struct {
+		name   string
+		params func(*config.Params)
+	}{
+		{name: "metrics", params: func(p *config.Params) {
+			for i := range 20 {
+				p.Metrics = append(p.Metrics, "metric_"+strconv.Itoa(i))
+			}
+		}},
+		{name: "sketches", params: func(p *config.Params) {
+			p.AggFuncs = []string{"p99"}
+			p.SketchMaxBins = 1000
+		}},
+		{name: "hll precision", params: func(p *config.Params) {
+			p.Distinct = true
+			p.HllPrecision = 12
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := testParams("1")
+			tt.params(&params)
+			if _, err := newTestNode(t, params); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestNewRejectsInvalidSketchParams(t *testing.T) {
+	quietLogs(t)
+	tests := []struct {
+		alpha   float64
+		maxBins int
+	}{
+		{alpha: 0, maxBins: 64},
+		{alpha: -0.01, maxBins: 64},
+		{alpha: 1, maxBins: 64},
+		{alpha: 0.01, maxBins: 0},
+		{alpha: 0.01, maxBins: -1},
+	}
+	for _, tt := range tests {
+		params := testParams("1")
+		params.AggFuncs = []string{"p50"}
+		params.SketchAlpha = tt.alpha
+		params.SketchMaxBins = tt.maxBins
+		if _, err := newTestNode(t, params); err == nil {
+			t.Errorf("alpha %g and %d bins accepted", tt.alpha, tt.maxBins)
+		}
+	}
+}
+
+func TestSetSamplesDropsSeriesThatDoNotFitInAPacket(t *testing.T) {
+	quietLogs(t)
+	params := testParams("1")
+	params.Metrics = []string{AllMetrics}
+	h, err := newTestNode(t, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := make([]Sample, 0, 100)
+	for i := range 100 {
+		samples = append(samples, Sample{Name: fmt.Sprintf("metric_%03d", i), Value: 1})
+	}
+	h.SetSamples(samples)
+	if len(h.Values) == 0 || len(h.Values) == len(samples) {
+		t.Fatalf("kept %d of %d series", len(h.Values), len(samples))
+	}
+	for i := range len(h.Values) {
+		if _, ok := h.Values[samples[i].Name]; !ok {
+			t.Fatalf("dropped %s but kept a later series", samples[i].Name)
+		}
+	}
+	msg := LocalAggMsg{TreeID: "1", Metrics: h.localAggregate().Metrics, Count: 1}
+	data, err := EncodeMsg(msg, params.WireFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > h.Peers.MaxMessageSize() {
+		t.Fatalf("aggregate of %d bytes does not fit in %d", len(data), h.Peers.MaxMessageSize())
+	}
+}
+
+func TestElectionStopsWithNode(t *testing.T) {
+	quietLogs(t)
+	c := clock.NewFake(time.Unix(0, 0))
+	h, err := New(
+		WithParams(testParams("1")),
+		WithPeerConfig(config.Config{NodeID: "1"}),
+		WithTransport(peers.NewMemNetwork(1).Transport("node_1:8000")),
+		WithClock(c),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Stop()
+	h.electionStep()
+	if c.Pending() != 0 || len(h.Trees) != 0 {
+		t.Fatalf("stopped node scheduled %d timers and has %d trees", c.Pending(), len(h.Trees))
+	}
+}
+
+func TestRemovePeerLeavesTrees(t *testing.T) {
+	quietLogs(t)
+	h, err := New(
+		WithParams(testParams("1")),
+		WithPeerConfig(config.Config{NodeID: "1", PeersIDs: []string{"2"}, PeersAddrs: []string{testAddr(2)}}),
+		WithTransport(peers.NewMemNetwork(1).Transport(testAddr(1))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Peers.Close() })
+	tree := h.getOrCreateTree("1", 0)
+	if len(tree.Children) != 1 {
+		t.Fatalf("tree has %d children, want the peer", len(tree.Children))
+	}
+
+	h.RemovePeer("2")
+	if len(h.Peers.GetPeers()) != 0 || len(tree.Children) != 0 {
+		t.Fatalf("peer still in the active view or the tree: %d peers, %d children",
+			len(h.Peers.GetPeers()), len(tree.Children))
+	}
+}
+
+func TestNonFiniteValuesAreSkipped(t *testing.T) {
+	quietLogs(t)
+	params := testParams("1")
+	params.Metrics = []string{AllMetrics}
+	h, err := newTestNode(t, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetValue("a", 1)
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		h.SetValue("a", f)
+		h.SetValue("b", f)
+	}
+	h.SetSamples([]Sample{{Name: "c", Value: math.NaN()}, {Name: "d", Value: 2}})
+	if len(h.Values) != 2 || h.Values["a"] != 1 || h.Values["d"] != 2 {
+		t.Fatalf("got values %v, want a=1 and d=2", h.Values)
+	}
+}
+
+func TestNewChecksNodeID(t *testing.T) {
+	quietLogs(t)
+	h, err := New(
+		WithParams(testParams("1")),
+		WithPeerConfig(config.Config{}),
+		WithTransport(peers.NewMemNetwork(1).Transport("node_1:8000")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Peers.Close()
+	if id := h.Peers.ID(); id != "1" {
+		t.Fatalf("node ID %q, want the params ID", id)
+	}
+
+	_, err = New(
+		WithParams(testParams("1")),
+		WithPeerConfig(config.Config{NodeID: "2"}),
+		WithTransport(peers.NewMemNetwork(1).Transport("node_1:8000")),
+	)
+	if !errors.Is(err, ErrNodeID) {
+		t.Fatalf("got %v for a different peer config node ID, want %v", err, ErrNodeID)
+	}
+	_, err = New(WithParams(testParams("1")), WithPeers(h.Peers))
+	if err != nil {
+		t.Fatalf("peers with the same ID rejected: %v", err)
+	}
+	_, err = New(WithParams(testParams("2")), WithPeers(h.Peers))
+	if !errors.Is(err, ErrNodeID) {
+		t.Fatalf("got %v for peers with a different ID, want %v", err, ErrNodeID)
+	}
+}
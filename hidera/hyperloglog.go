@@ -0,0 +1,94 @@
+package hidera
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// HyperLogLog estimates the number of distinct items added to it. Merging
+// takes the register-wise maximum, so adding the same item on several nodes,
+// or merging the same partial twice, does not change the estimate.
+type HyperLogLog struct {
+	Precision uint8
+	Registers []byte
+}
+
+func NewHyperLogLog(precision uint8) *HyperLogLog {
+	return &HyperLogLog{
+		Precision: precision,
+		Registers: make([]byte, 1<<precision),
+	}
+}
+
+func (hll *HyperLogLog) Add(item string) {
+	hash := hashItem(item)
+	idx := hash >> (64 - hll.Precision)
+	rank := byte(bits.LeadingZeros64(hash<<hll.Precision|1<<(hll.Precision-1)) + 1)
+	if rank > hll.Registers[idx] {
+		hll.Registers[idx] = rank
+	}
+}
+
+func (hll *HyperLogLog) Clone() *HyperLogLog {
+	return &HyperLogLog{
+		Precision: hll.Precision,
+		Registers: slices.Clone(hll.Registers),
+	}
+}
+
+// Merge ignores sketches of a different precision.
+func (hll *HyperLogLog) Merge(other *HyperLogLog) {
+	if other == nil || other.Precision != hll.Precision || len(other.Registers) != len(hll.Registers) {
+		return
+	}
+	for i, r := range other.Registers {
+		if r > hll.Registers[i] {
+			hll.Registers[i] = r
+		}
+	}
+}
+
+func (hll *HyperLogLog) Estimate() float64 {
+	m := float64(len(hll.Registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range hll.Registers {
+		sum += math.Pow(2, -float64(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := hllAlpha(m) * m * m / sum
+	// linear counting is more accurate for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		return m * math.Log(m/float64(zeros))
+	}
+	return estimate
+}
+
+func hllAlpha(m float64) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/m)
+}
+
+func hashItem(item string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	// fnv alone mixes the high bits poorly, finish with the murmur3 mixer
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
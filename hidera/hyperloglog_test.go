@@ -0,0 +1,68 @@
+package hidera
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLogEstimate(t *testing.T) {
+	for _, precision := range []uint8{8, 12, 14} {
+		// four standard errors
+		tolerance := 4 * 1.04 / math.Sqrt(float64(int(1)<<precision))
+		for _, n := range []int{10, 100, 1000, 10000, 100000} {
+			hll := NewHyperLogLog(precision)
+			for i := range n {
+				hll.Add("item_" + strconv.Itoa(i))
+				// duplicates do not count
+				hll.Add("item_" + strconv.Itoa(i/2))
+			}
+			if err := math.Abs(hll.Estimate()-float64(n)) / float64(n); err > tolerance {
+				t.Errorf("precision %d: estimate %g of %d items, error %.3f above %.3f",
+					precision, hll.Estimate(), n, err, tolerance)
+			}
+		}
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	for _, n := range []int{100, 10000} {
+		all := NewHyperLogLog(12)
+		parts := []*HyperLogLog{NewHyperLogLog(12), NewHyperLogLog(12), NewHyperLogLog(12)}
+		for i := range n {
+			item := "item_" + strconv.Itoa(i)
+			all.Add(item)
+			// every item is seen by one or two nodes
+			parts[i%3].Add(item)
+			if i%2 == 0 {
+				parts[(i+1)%3].Add(item)
+			}
+		}
+		merged := parts[0].Clone()
+		for _, p := range parts[1:] {
+			merged.Merge(p)
+		}
+		if !slices.Equal(merged.Registers, all.Registers) {
+			t.Fatalf("%d items: merged registers differ from one HLL of all items", n)
+		}
+		// merging again changes nothing
+		merged.Merge(parts[1])
+		if merged.Estimate() != all.Estimate() {
+			t.Fatalf("%d items: estimate changed to %g after a repeated merge, want %g", n, merged.Estimate(), all.Estimate())
+		}
+		if parts[0].Estimate() >= merged.Estimate() {
+			t.Fatalf("%d items: merging did not grow the estimate", n)
+		}
+	}
+}
+
+func TestHyperLogLogMergeIgnoresOtherPrecision(t *testing.T) {
+	a, b := NewHyperLogLog(8), NewHyperLogLog(10)
+	b.Add("item")
+	a.Merge(b)
+	a.Merge(nil)
+	if a.Estimate() != 0 {
+		t.Fatalf("estimate %g after merging another precision", a.Estimate())
+	}
+}
@@ -22,6 +22,10 @@ func NewLagMetric(peers []peers.Peer) *LagMetric {
 }
 
 func (lm *LagMetric) Add(peerId string, round int) {
+	// peers can be added after the tree was created
+	if lm.lagByPeer[peerId] == nil {
+		lm.lagByPeer[peerId] = make(map[int]struct{})
+	}
 	lm.lagByPeer[peerId][round] = struct{}{}
 }
 
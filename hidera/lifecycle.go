@@ -0,0 +1,187 @@
+package hidera
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/tamararankovic/hidera/peers"
+)
+
+const subscriberBuffer = 16
+
+// Start runs the node until ctx is done or Stop is called.
+func (h *Hidera) Start(ctx context.Context) error {
+	h.Lock.Lock()
+	if h.stopped {
+		h.Lock.Unlock()
+		return ErrStopped
+	}
+	if h.started {
+		h.Lock.Unlock()
+		return ErrStarted
+	}
+	h.started = true
+	h.ctx, h.cancel = context.WithCancel(ctx)
+	h.Lock.Unlock()
+
+	h.Run()
+	if h.sinks != nil {
+		var export func()
+		export = func() {
+			if h.isStopped() {
+				return
+			}
+			h.Clock.AfterFunc(time.Second, export)
+			h.export()
+		}
+		h.Clock.AfterFunc(time.Second, export)
+	}
+	go func() {
+		<-h.ctx.Done()
+		h.Stop()
+	}()
+	return nil
+}
+
+// Stop tells the peers that the node leaves, stops the rounds, closes the
+// subscription channels and closes the peers if they were created by New.
+func (h *Hidera) Stop() {
+	h.Lock.Lock()
+	if h.stopped {
+		h.Lock.Unlock()
+		return
+	}
+	h.stopped = true
+	started := h.started
+	if h.cancel != nil {
+		h.cancel()
+	}
+	for _, ch := range h.subscribers {
+		close(ch)
+	}
+	h.subscribers = nil
+	for _, ch := range h.peerEvents {
+		close(ch)
+	}
+	h.peerEvents = nil
+	h.Lock.Unlock()
+
+	if started {
+		h.leave()
+	}
+
+	log.Printf("[STOP] Node %s stopped", h.Params.ID)
+	if h.ownsPeers {
+		if err := h.Peers.Close(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (h *Hidera) isStopped() bool {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	return h.stopped
+}
+
+func (h *Hidera) Value(name string) (float64, bool) {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	value, ok := h.Values[name]
+	return value, ok
+}
+
+// Subscribe returns a channel that receives the aggregate state every time
+// the best tree delivers a new global aggregate. If the subscriber falls
+// behind, the oldest states are dropped. The channel is closed by Stop.
+func (h *Hidera) Subscribe() <-chan AggregateState {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	ch := make(chan AggregateState, subscriberBuffer)
+	if h.stopped {
+		close(ch)
+		return ch
+	}
+	h.subscribers = append(h.subscribers, ch)
+	return ch
+}
+
+func (h *Hidera) publish() {
+	if len(h.subscribers) == 0 {
+		return
+	}
+	state := h.aggregateState()
+	if state == nil {
+		return
+	}
+	if h.published != nil && h.published.TreeID == state.TreeID && h.published.Round == state.Round {
+		return
+	}
+	h.published = state
+	for _, ch := range h.subscribers {
+		sendDropOldest(ch, *state)
+	}
+}
+
+const (
+	PEER_SUSPECTED = "suspected"
+	PEER_ALIVE     = "alive"
+	PEER_FAILED    = "failed"
+)
+
+// PeerEvent reports that a peer was suspected by the failure detector,
+// answered the probes that followed, or did not answer and failed.
+type PeerEvent struct {
+	Type   string
+	PeerID string
+	Round  int
+}
+
+// SubscribePeerEvents returns a channel that receives the liveness changes
+// of the peers, the oldest events are dropped if the subscriber falls
+// behind. The channel is closed by Stop.
+func (h *Hidera) SubscribePeerEvents() <-chan PeerEvent {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	ch := make(chan PeerEvent, subscriberBuffer)
+	if h.stopped {
+		close(ch)
+		return ch
+	}
+	h.peerEvents = append(h.peerEvents, ch)
+	return ch
+}
+
+func (h *Hidera) emitPeerEvent(eventType string, peer peers.Peer) {
+	event := PeerEvent{Type: eventType, PeerID: peer.GetID(), Round: h.Round}
+	for _, ch := range h.peerEvents {
+		sendDropOldest(ch, event)
+	}
+}
+
+// sendDropOldest sends v to a buffered channel that only the caller sends
+// to, making room by dropping the oldest value if it is full.
+func sendDropOldest[T any](ch chan T, v T) {
+	select {
+	case ch <- v:
+	default:
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- v
+	}
+}
+
+func (h *Hidera) pollSource() {
+	if h.source == nil {
+		return
+	}
+	samples, err := h.source.Samples(h.ctx)
+	if err != nil {
+		log.Printf("[SOURCE] Node %s failed to read values: %v", h.Params.ID, err)
+		return
+	}
+	h.SetSamples(samples)
+}
@@ -2,12 +2,57 @@ package hidera
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/tamararankovic/hidera/peers"
 )
 
 const LOCAL_AGG_MSG_TYPE int8 = 1
 const GLOBAL_AGG_MSG_TYPE int8 = 2
 const GLOBAL_AGG_LAZY_MSG_TYPE int8 = 3
 const PING_MSG_TYPE int8 = 4
+const LEAVE_MSG_TYPE int8 = 5
+
+func MsgTypeName(msgType int8) string {
+	switch msgType {
+	case LOCAL_AGG_MSG_TYPE:
+		return "local_agg"
+	case GLOBAL_AGG_MSG_TYPE:
+		return "global_agg"
+	case GLOBAL_AGG_LAZY_MSG_TYPE:
+		return "global_agg_lazy"
+	case PING_MSG_TYPE:
+		return "ping"
+	case LEAVE_MSG_TYPE:
+		return "leave"
+	case int8(peers.JOIN_MSG_TYPE):
+		return "join"
+	case int8(peers.JOIN_REPLY_MSG_TYPE):
+		return "join_reply"
+	case int8(peers.FORWARD_JOIN_MSG_TYPE):
+		return "forward_join"
+	case int8(peers.NEIGHBOR_MSG_TYPE):
+		return "neighbor"
+	case int8(peers.NEIGHBOR_REPLY_MSG_TYPE):
+		return "neighbor_reply"
+	case int8(peers.DISCONNECT_MSG_TYPE):
+		return "disconnect"
+	case int8(peers.SHUFFLE_MSG_TYPE):
+		return "shuffle"
+	case int8(peers.SHUFFLE_REPLY_MSG_TYPE):
+		return "shuffle_reply"
+	case int8(peers.PROBE_MSG_TYPE):
+		return "probe"
+	case int8(peers.PROBE_ACK_MSG_TYPE):
+		return "probe_ack"
+	case int8(peers.PING_REQ_MSG_TYPE):
+		return "ping_req"
+	}
+	return strconv.Itoa(int(msgType))
+}
 
 type Msg interface {
 	Type() int8
@@ -15,8 +60,9 @@ type Msg interface {
 
 type LocalAggMsg struct {
 	TreeID      string
-	Value       float64
+	Metrics     map[string]MetricAgg
 	Count       int
+	Distinct    *HyperLogLog `json:",omitempty"`
 	SenderRound int
 }
 
@@ -26,8 +72,9 @@ func (m LocalAggMsg) Type() int8 {
 
 type GlobalAggMsg struct {
 	TreeID      string
-	Value       float64
+	Metrics     map[string]MetricAgg
 	Count       int
+	Distinct    *HyperLogLog `json:",omitempty"`
 	Level       int
 	ValueRound  int
 	SenderRound int
@@ -54,12 +101,38 @@ func (m PingMsg) Type() int8 {
 	return PING_MSG_TYPE
 }
 
-func MsgToBytes(msg Msg) []byte {
-	msgBytes, _ := json.Marshal(&msg)
-	return append([]byte{byte(msg.Type())}, msgBytes...)
+// LeaveMsg is sent to all peers by a node that shuts down. If the node is
+// the root of a tree, TreeID names the tree, Successor the child that takes
+// it over and ValueRound the round of the last global aggregate.
+type LeaveMsg struct {
+	TreeID     string `json:",omitempty"`
+	Successor  string `json:",omitempty"`
+	ValueRound int
+}
+
+func (m LeaveMsg) Type() int8 {
+	return LEAVE_MSG_TYPE
+}
+
+func MsgToBytes(msg Msg) ([]byte, error) {
+	msgBytes, err := json.Marshal(&msg)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(msg.Type())}, msgBytes...), nil
 }
 
-func BytesToMsg(msgBytes []byte) Msg {
+var (
+	ErrEmptyMsg           = errors.New("empty message")
+	ErrUnknownMsgType     = errors.New("unknown message type")
+	ErrUnknownWireVersion = errors.New("unknown wire format version")
+	ErrMalformedMsg       = errors.New("malformed message")
+)
+
+func BytesToMsg(msgBytes []byte) (Msg, error) {
+	if len(msgBytes) == 0 {
+		return nil, ErrEmptyMsg
+	}
 	msgType := int8(msgBytes[0])
 	var msg Msg
 	switch msgType {
@@ -70,11 +143,61 @@ func BytesToMsg(msgBytes []byte) Msg {
 	case GLOBAL_AGG_LAZY_MSG_TYPE:
 		msg = &GlobalAggLazyMsg{}
 	case PING_MSG_TYPE:
-		msg = &PingMsg{}
+		return &PingMsg{}, nil
+	case LEAVE_MSG_TYPE:
+		msg = &LeaveMsg{}
+	default:
+		return nil, fmt.Errorf("%w %d", ErrUnknownMsgType, msgType)
+	}
+	if len(msgBytes) < 2 {
+		return nil, fmt.Errorf("%w: missing payload", ErrMalformedMsg)
+	}
+	switch msgBytes[1] {
+	case '{':
+		if err := json.Unmarshal(msgBytes[1:], msg); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedMsg, err)
+		}
+	case BINARY_WIRE_VERSION:
+		var err error
+		msg, err = binaryToMsg(msgType, msgBytes[2:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedMsg, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w %d", ErrUnknownWireVersion, msgBytes[1])
+	}
+	if err := validateMsg(msg); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedMsg, err)
+	}
+	return msg, nil
+}
+
+// validateMsg rejects content that would corrupt the partials it is merged
+// into, which the JSON decoder lets through.
+func validateMsg(msg Msg) error {
+	var metrics map[string]MetricAgg
+	var distinct *HyperLogLog
+	switch m := msg.(type) {
+	case *LocalAggMsg:
+		metrics, distinct = m.Metrics, m.Distinct
+	case *GlobalAggMsg:
+		metrics, distinct = m.Metrics, m.Distinct
+	}
+	for name, m := range metrics {
+		if m.Count < 0 {
+			return fmt.Errorf("negative count of %s", name)
+		}
+		for _, f := range []float64{m.Value, m.Min, m.Max, m.M2} {
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return fmt.Errorf("non-finite value of %s", name)
+			}
+		}
+		if m.Sketch != nil && (m.Sketch.Alpha <= 0 || m.Sketch.Alpha >= 1) {
+			return fmt.Errorf("invalid sketch accuracy of %s", name)
+		}
 	}
-	if msg.Type() == PING_MSG_TYPE {
-		return msg
+	if distinct != nil && (distinct.Precision < 4 || distinct.Precision > 16 || len(distinct.Registers) != 1<<distinct.Precision) {
+		return errors.New("invalid HLL")
 	}
-	json.Unmarshal(msgBytes[1:], msg)
-	return msg
+	return nil
 }
@@ -0,0 +1,84 @@
+package hidera
+
+import (
+	"errors"
+	"testing"
+)
+
+func testMsgs() []Msg {
+	sketch := NewQuantileSketch(0.01, 64)
+	for _, v := range []float64{-3, 0, 0.5, 2, 40, 1e6} {
+		sketch.Add(v)
+	}
+	hll := NewHyperLogLog(8)
+	for _, item := range []string{"a", "b", "c"} {
+		hll.Add(item)
+	}
+	metrics := map[string]MetricAgg{
+		"cpu":    {Value: 1.5, Count: 3, Min: 0.2, Max: 0.9, M2: 1.1, Sketch: sketch},
+		"memory": NewMetricAgg(512),
+	}
+	return []Msg{
+		LocalAggMsg{TreeID: "node_7", Metrics: metrics, Count: 3, Distinct: hll, SenderRound: 42},
+		GlobalAggMsg{TreeID: "node_7", Metrics: metrics, Count: 10, Level: 2, ValueRound: 40, SenderRound: 42},
+		GlobalAggLazyMsg{TreeID: "node_7", ValueRound: 40, SenderRound: 42},
+		PingMsg{},
+		LeaveMsg{TreeID: "node_7", Successor: "node_3", ValueRound: 40},
+	}
+}
+
+// deref returns the value a decoded message points to, the encoders take
+// messages by value.
+func deref(msg Msg) Msg {
+	switch m := msg.(type) {
+	case *LocalAggMsg:
+		return *m
+	case *GlobalAggMsg:
+		return *m
+	case *GlobalAggLazyMsg:
+		return *m
+	case *PingMsg:
+		return *m
+	case *LeaveMsg:
+		return *m
+	}
+	return msg
+}
+
+func FuzzBytesToMsg(f *testing.F) {
+	for _, msg := range testMsgs() {
+		jsonBytes, err := MsgToBytes(msg)
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, b := range [][]byte{jsonBytes, MsgToBinary(msg)} {
+			f.Add(b)
+			f.Add(b[:len(b)/2])
+			f.Add(b[:len(b)-1])
+		}
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0})
+	f.Add([]byte{99, '{', '}'})
+	f.Add([]byte{byte(LOCAL_AGG_MSG_TYPE), 7})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := BytesToMsg(data)
+		if err != nil {
+			if msg != nil {
+				t.Fatalf("got message %+v with error %v", msg, err)
+			}
+			if !errors.Is(err, ErrEmptyMsg) && !errors.Is(err, ErrUnknownMsgType) &&
+				!errors.Is(err, ErrUnknownWireVersion) && !errors.Is(err, ErrMalformedMsg) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			return
+		}
+		if len(data) < 2 || data[1] != BINARY_WIRE_VERSION {
+			return
+		}
+		if _, err := BytesToMsg(MsgToBinary(deref(msg))); err != nil {
+			t.Fatalf("re-encoded %+v does not decode: %v", msg, err)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package hidera
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// maxCount bounds the counts in aggregates, which are at most the number of
+// nodes.
+const maxCount = math.MaxUint32
+
+// worstCaseMsgSize returns the largest an aggregate message carrying the
+// series keys can get, with every number at its longest encoding and every
+// sketch and HLL full.
+func (h *Hidera) worstCaseMsgSize(keys []string) int {
+	const n = math.MaxInt64
+	f := -1.2345678901234567e-300
+	metrics := make(map[string]MetricAgg, len(keys))
+	for _, key := range keys {
+		m := MetricAgg{Value: f, Count: maxCount, Min: f, Max: f, M2: f}
+		if needsSketch(h.AggFuncs) {
+			m.Sketch = worstCaseSketch(h.Params.SketchAlpha, h.Params.SketchMaxBins)
+		}
+		metrics[key] = m
+	}
+	var distinct *HyperLogLog
+	if h.Distinct != nil {
+		distinct = NewHyperLogLog(h.Distinct.Precision)
+	}
+	treeID := strconv.Itoa(math.MinInt64)
+	local := LocalAggMsg{TreeID: treeID, Metrics: metrics, Count: maxCount, Distinct: distinct, SenderRound: n}
+	global := GlobalAggMsg{TreeID: treeID, Metrics: metrics, Count: maxCount, Distinct: distinct, Level: n, ValueRound: n, SenderRound: n}
+	// the values are finite, encoding does not fail
+	localBytes, _ := EncodeMsg(local, h.Params.WireFormat)
+	globalBytes, _ := EncodeMsg(global, h.Params.WireFormat)
+	return max(len(localBytes), len(globalBytes))
+}
+
+// worstCaseSketch spreads maxBins bins over the whole float range.
+func worstCaseSketch(alpha float64, maxBins int) *QuantileSketch {
+	s := NewQuantileSketch(alpha, maxBins)
+	s.Zeros = maxCount
+	lo, hi := s.index(math.SmallestNonzeroFloat64), s.index(math.MaxFloat64)
+	for i := range maxBins {
+		s.Bins[lo+i*(hi-lo)/maxBins] = maxCount
+	}
+	return s
+}
+
+// checkMsgSize rejects params whose configured metrics cannot be sent in a
+// single packet.
+func (h *Hidera) checkMsgSize() error {
+	keys := slices.Sorted(maps.Keys(h.Values))
+	if size := h.worstCaseMsgSize(keys); size > h.maxMsgSize {
+		return fmt.Errorf("aggregates of %d metrics take up to %d bytes, more than the %d that fit in a packet, track fewer METRICS, lower SKETCH_MAX_BINS or HLL_PRECISION or use the binary WIRE_FORMAT",
+			len(keys), size, h.maxMsgSize)
+	}
+	return nil
+}
+
+// capSeries drops series in reverse key order until their aggregates fit in
+// a packet, so that nodes with the same series drop the same ones. With
+// METRICS=* or GROUP_BY the number of series is only known at runtime.
+func (h *Hidera) capSeries() {
+	keys := slices.Sorted(maps.Keys(h.Values))
+	if h.worstCaseMsgSize(keys) <= h.maxMsgSize {
+		return
+	}
+	fit := sort.Search(len(keys), func(n int) bool {
+		return h.worstCaseMsgSize(keys[:n+1]) > h.maxMsgSize
+	})
+	for _, key := range keys[fit:] {
+		delete(h.Values, key)
+	}
+	log.Printf("[WARN] Node %s dropped %d of %d series, their aggregates do not fit in a packet",
+		h.Params.ID, len(keys)-fit, len(keys))
+}
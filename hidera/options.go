@@ -0,0 +1,138 @@
+package hidera
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tamararankovic/hidera/clock"
+	"github.com/tamararankovic/hidera/config"
+	"github.com/tamararankovic/hidera/peers"
+	"github.com/tamararankovic/hidera/sink"
+)
+
+// ValueSource provides the local samples of a node. It is polled before
+// every round, its samples replace the values of the metrics they contain
+// like SetSamples does.
+type ValueSource interface {
+	Samples(ctx context.Context) ([]Sample, error)
+}
+
+type ValueSourceFunc func(ctx context.Context) ([]Sample, error)
+
+func (f ValueSourceFunc) Samples(ctx context.Context) ([]Sample, error) {
+	return f(ctx)
+}
+
+type Option func(*options)
+
+type options struct {
+	params     *config.Params
+	peers      *peers.Peers
+	peerConfig *config.Config
+	transport  peers.Transport
+	source     ValueSource
+	sinks      sink.Sink
+	clock      clock.Clock
+	detector   FailureDetector
+}
+
+func WithParams(params config.Params) Option {
+	return func(o *options) { o.params = &params }
+}
+
+// WithPeers uses peers that were created by the caller, who also closes
+// them.
+func WithPeers(ps *peers.Peers) Option {
+	return func(o *options) { o.peers = ps }
+}
+
+// WithPeerConfig makes New create the peers from conf, they are closed by
+// Stop.
+func WithPeerConfig(conf config.Config) Option {
+	return func(o *options) { o.peerConfig = &conf }
+}
+
+// WithTransport replaces the transport selected by the peer config.
+func WithTransport(transport peers.Transport) Option {
+	return func(o *options) { o.transport = transport }
+}
+
+func WithValueSource(source ValueSource) Option {
+	return func(o *options) { o.source = source }
+}
+
+// WithSinks makes the node write its aggregates and message counts to sinks
+// every second. The sinks are not closed by Stop.
+func WithSinks(sinks sink.Sink) Option {
+	return func(o *options) { o.sinks = sinks }
+}
+
+func WithClock(c clock.Clock) Option {
+	return func(o *options) { o.clock = c }
+}
+
+// WithFailureDetector replaces the failure detector selected by the params.
+func WithFailureDetector(detector FailureDetector) Option {
+	return func(o *options) { o.detector = detector }
+}
+
+var (
+	ErrNoParams = errors.New("hidera: params are required")
+	ErrNoPeers  = errors.New("hidera: peers or a peer config are required")
+	ErrStarted  = errors.New("hidera: already started")
+	ErrStopped  = errors.New("hidera: stopped")
+	ErrNodeID   = errors.New("hidera: the params ID and the node ID of the peers differ")
+)
+
+// New creates a node from options, unlike NewHidera it returns invalid
+// parameters as errors. The node ID of a peer config defaults to the params
+// ID. The node does nothing until Start is called.
+func New(opts ...Option) (*Hidera, error) {
+	o := options{clock: clock.Real{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.params == nil {
+		return nil, ErrNoParams
+	}
+	ps, ownsPeers := o.peers, false
+	if ps == nil {
+		if o.peerConfig == nil {
+			return nil, ErrNoPeers
+		}
+		conf := *o.peerConfig
+		if conf.NodeID == "" {
+			conf.NodeID = o.params.ID
+		}
+		if conf.NodeID != o.params.ID {
+			return nil, ErrNodeID
+		}
+		var err error
+		if o.transport != nil {
+			ps, err = peers.NewPeersWithTransport(conf, o.transport)
+		} else {
+			ps, err = peers.NewPeers(conf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ownsPeers = true
+	} else if ps.ID() != o.params.ID {
+		return nil, ErrNodeID
+	}
+	h, err := newHidera(*o.params, ps)
+	if err != nil {
+		if ownsPeers {
+			ps.Close()
+		}
+		return nil, err
+	}
+	h.Clock = o.clock
+	if o.detector != nil {
+		h.Detector = o.detector
+	}
+	h.source = o.source
+	h.sinks = o.sinks
+	h.ownsPeers = ownsPeers
+	return h, nil
+}
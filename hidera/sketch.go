@@ -0,0 +1,152 @@
+package hidera
+
+import (
+	"maps"
+	"math"
+	"slices"
+)
+
+// QuantileSketch is a DDSketch: values are counted in logarithmically sized
+// bins, so every quantile estimate is within Alpha relative error and two
+// sketches with the same Alpha merge by adding bin counts.
+type QuantileSketch struct {
+	Alpha   float64
+	MaxBins int
+	Bins    map[int]uint64
+	NegBins map[int]uint64
+	Zeros   uint64
+}
+
+func NewQuantileSketch(alpha float64, maxBins int) *QuantileSketch {
+	return &QuantileSketch{
+		Alpha:   alpha,
+		MaxBins: maxBins,
+		Bins:    make(map[int]uint64),
+		NegBins: make(map[int]uint64),
+	}
+}
+
+func (s *QuantileSketch) gamma() float64 {
+	return (1 + s.Alpha) / (1 - s.Alpha)
+}
+
+func (s *QuantileSketch) index(value float64) int {
+	return int(math.Ceil(math.Log(value) / math.Log(s.gamma())))
+}
+
+func (s *QuantileSketch) binValue(index int) float64 {
+	return 2 * math.Pow(s.gamma(), float64(index)) / (s.gamma() + 1)
+}
+
+func (s *QuantileSketch) Add(value float64) {
+	if math.IsNaN(value) {
+		return
+	}
+	switch {
+	case value > 0:
+		s.Bins[s.index(value)]++
+	case value < 0:
+		s.NegBins[s.index(-value)]++
+	default:
+		s.Zeros++
+	}
+	s.collapse()
+}
+
+func (s *QuantileSketch) Count() uint64 {
+	count := s.Zeros
+	for _, c := range s.Bins {
+		count += c
+	}
+	for _, c := range s.NegBins {
+		count += c
+	}
+	return count
+}
+
+func (s *QuantileSketch) Clone() *QuantileSketch {
+	return &QuantileSketch{
+		Alpha:   s.Alpha,
+		MaxBins: s.MaxBins,
+		Bins:    maps.Clone(s.Bins),
+		NegBins: maps.Clone(s.NegBins),
+		Zeros:   s.Zeros,
+	}
+}
+
+// Merge adds the bins of other into s. Sketches built with a different Alpha
+// cannot be merged and are ignored.
+func (s *QuantileSketch) Merge(other *QuantileSketch) {
+	if other == nil || other.Alpha != s.Alpha {
+		return
+	}
+	if s.Bins == nil {
+		s.Bins = make(map[int]uint64)
+	}
+	if s.NegBins == nil {
+		s.NegBins = make(map[int]uint64)
+	}
+	for i, c := range other.Bins {
+		s.Bins[i] += c
+	}
+	for i, c := range other.NegBins {
+		s.NegBins[i] += c
+	}
+	s.Zeros += other.Zeros
+	s.collapse()
+}
+
+func (s *QuantileSketch) Quantile(q float64) float64 {
+	count := s.Count()
+	if count == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+	rank := uint64(q * float64(count-1))
+	seen := uint64(0)
+
+	negIdx := slices.Sorted(maps.Keys(s.NegBins))
+	for _, i := range slices.Backward(negIdx) {
+		seen += s.NegBins[i]
+		if seen > rank {
+			return -s.binValue(i)
+		}
+	}
+	seen += s.Zeros
+	if seen > rank {
+		return 0
+	}
+	posIdx := slices.Sorted(maps.Keys(s.Bins))
+	for _, i := range posIdx {
+		seen += s.Bins[i]
+		if seen > rank {
+			return s.binValue(i)
+		}
+	}
+	return s.binValue(posIdx[len(posIdx)-1])
+}
+
+// collapse keeps the sketch bounded by folding the bins of the lowest values
+// together, which sacrifices accuracy only for the lowest quantiles. These
+// are the most negative values, the bins with the highest indices of
+// NegBins, and only once they are folded into one the positive values
+// closest to zero.
+func (s *QuantileSketch) collapse() {
+	if s.MaxBins <= 0 {
+		return
+	}
+	for len(s.Bins)+len(s.NegBins) > s.MaxBins {
+		if len(s.NegBins) >= 2 {
+			idx := slices.Sorted(maps.Keys(s.NegBins))
+			last := len(idx) - 1
+			s.NegBins[idx[last-1]] += s.NegBins[idx[last]]
+			delete(s.NegBins, idx[last])
+			continue
+		}
+		idx := slices.Sorted(maps.Keys(s.Bins))
+		if len(idx) < 2 {
+			return
+		}
+		s.Bins[idx[1]] += s.Bins[idx[0]]
+		delete(s.Bins, idx[0])
+	}
+}
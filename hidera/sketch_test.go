@@ -0,0 +1,86 @@
+package hidera
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestQuantileSketchAccuracy(t *testing.T) {
+	const alpha = 0.01
+	seq := func(from, to float64) []float64 {
+		var values []float64
+		for v := from; v <= to; v++ {
+			values = append(values, v)
+		}
+		return values
+	}
+	tests := []struct {
+		name    string
+		values  []float64
+		maxBins int
+		// quantiles at or above minQ are within alpha
+		minQ float64
+	}{
+		{name: "positive", values: seq(1, 1000), maxBins: 1000},
+		{name: "negative", values: seq(-1000, -1), maxBins: 1000},
+		{name: "mixed", values: seq(-500, 500), maxBins: 1000},
+		{name: "positive collapsed", values: seq(1, 1000), maxBins: 64, minQ: 0.5},
+		{name: "negative collapsed", values: seq(-100, -1), maxBins: 64, minQ: 0.5},
+		{name: "mixed collapsed", values: seq(-100, 1000), maxBins: 64, minQ: 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewQuantileSketch(alpha, tt.maxBins)
+			for _, v := range tt.values {
+				s.Add(v)
+			}
+			if len(s.Bins)+len(s.NegBins) > tt.maxBins {
+				t.Fatalf("%d bins, want at most %d", len(s.Bins)+len(s.NegBins), tt.maxBins)
+			}
+			sorted := slices.Sorted(slices.Values(tt.values))
+			for _, q := range []float64{0, 0.01, 0.25, 0.5, 0.75, 0.9, 0.99, 1} {
+				if q < tt.minQ {
+					continue
+				}
+				want := sorted[int(q*float64(len(sorted)-1))]
+				got := s.Quantile(q)
+				if want == 0 {
+					if got != 0 {
+						t.Errorf("p%g = %g, want 0", q*100, got)
+					}
+					continue
+				}
+				if math.Abs(got-want)/math.Abs(want) > alpha {
+					t.Errorf("p%g = %g, want %g within %g", q*100, got, want, alpha)
+				}
+			}
+		})
+	}
+}
+
+func TestQuantileSketchMerge(t *testing.T) {
+	a, b, all := NewQuantileSketch(0.01, 64), NewQuantileSketch(0.01, 64), NewQuantileSketch(0.01, 64)
+	for v := -200.0; v <= 200; v++ {
+		all.Add(v)
+		if int(v)%2 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+	}
+	a.Merge(b)
+	if a.Count() != all.Count() {
+		t.Fatalf("merged count %d, want %d", a.Count(), all.Count())
+	}
+	for _, q := range []float64{0.5, 0.75, 0.99} {
+		if got, want := a.Quantile(q), all.Quantile(q); math.Abs(got-want)/math.Abs(want) > 0.01 {
+			t.Errorf("p%g of the merged sketch %g, want %g", q*100, got, want)
+		}
+	}
+	other := NewQuantileSketch(0.02, 64)
+	other.Merge(a)
+	if other.Count() != 0 {
+		t.Fatal("merged sketches of different accuracy")
+	}
+}
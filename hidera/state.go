@@ -0,0 +1,149 @@
+package hidera
+
+import (
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/tamararankovic/hidera/peers"
+)
+
+type AggregateState struct {
+	TreeID        string
+	Round         int
+	Freshness     int
+	Count         int
+	CountEstimate int
+	Results       map[string]map[string]float64
+	Distinct      *float64 `json:",omitempty"`
+}
+
+type TreeState struct {
+	ID                string
+	IsRoot            bool
+	IsBest            bool
+	Level             int
+	Parent            *string
+	Children          []string
+	Lazy              []string
+	FirstGlobalRound  int
+	LastGlobalRound   int
+	ParentLastChanged int
+	ValueRound        *int
+}
+
+type PeerState struct {
+	ID           string
+	Addr         string
+	Failed       bool
+	Suspect      bool
+	LastMsgRound *int
+	SilentRounds *int
+	// Suspicion is the failure detector's suspicion level of the peer, 0
+	// for failed peers and peers it does not monitor.
+	Suspicion float64
+}
+
+// AggregateState returns the results of the best tree, or nil if the node
+// has not received a global aggregate yet. Results that are undefined, such
+// as the minimum of no values, are left out.
+func (h *Hidera) AggregateState() *AggregateState {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	return h.aggregateState()
+}
+
+func (h *Hidera) aggregateState() *AggregateState {
+	tree := h.FindBestTree()
+	if tree == nil || tree.GlobalAgg == nil {
+		return nil
+	}
+	state := &AggregateState{
+		TreeID:        tree.ID,
+		Round:         tree.GlobalAgg.Round,
+		Freshness:     h.Round - tree.LastGlobalRound,
+		Count:         tree.GlobalAgg.Count,
+		CountEstimate: h.CountEstimate,
+		Results:       make(map[string]map[string]float64),
+	}
+	for name, m := range tree.GlobalAgg.Metrics {
+		results := make(map[string]float64)
+		for _, f := range h.AggFuncs {
+			result := f.Result(m)
+			if math.IsNaN(result) || math.IsInf(result, 0) {
+				continue
+			}
+			results[f.Name()] = result
+		}
+		state.Results[name] = results
+	}
+	if tree.GlobalAgg.Distinct != nil {
+		distinct := tree.GlobalAgg.Distinct.Estimate()
+		state.Distinct = &distinct
+	}
+	return state
+}
+
+func (h *Hidera) TreeStates() []TreeState {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	best := h.FindBestTree()
+	states := make([]TreeState, 0, len(h.Trees))
+	for _, tree := range h.Trees {
+		state := TreeState{
+			ID:                tree.ID,
+			IsRoot:            tree.IsRoot,
+			IsBest:            best != nil && best.ID == tree.ID,
+			Level:             tree.Level,
+			Children:          peerIDs(tree.Children),
+			Lazy:              peerIDs(tree.Lazy),
+			FirstGlobalRound:  tree.FirstGlobalRound,
+			LastGlobalRound:   tree.LastGlobalRound,
+			ParentLastChanged: tree.ParentLastChanged,
+		}
+		if tree.Parent != nil {
+			parent := tree.Parent.GetID()
+			state.Parent = &parent
+		}
+		if tree.GlobalAgg != nil {
+			round := tree.GlobalAgg.Round
+			state.ValueRound = &round
+		}
+		states = append(states, state)
+	}
+	slices.SortFunc(states, func(a, b TreeState) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return states
+}
+
+func (h *Hidera) PeerStates() []PeerState {
+	h.Lock.Lock()
+	defer h.Lock.Unlock()
+	now := h.Clock.Now()
+	states := make([]PeerState, 0)
+	for _, p := range append(h.Peers.GetPeers(), h.Peers.GetFailedPeers()...) {
+		state := PeerState{
+			ID:        p.GetID(),
+			Addr:      p.GetAddr(),
+			Failed:    p.IsFailed(),
+			Suspect:   p.IsSuspect(),
+			Suspicion: h.Detector.Suspicion(p.GetID(), h.Round, now),
+		}
+		if round, ok := h.LastMsg[p.GetID()]; ok {
+			silent := h.Round - round
+			state.LastMsgRound = &round
+			state.SilentRounds = &silent
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+func peerIDs(ps []peers.Peer) []string {
+	ids := make([]string, 0, len(ps))
+	for _, p := range ps {
+		ids = append(ids, p.GetID())
+	}
+	return ids
+}
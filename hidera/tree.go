@@ -4,6 +4,7 @@ import (
 	"log"
 	"maps"
 	"slices"
+	"sort"
 
 	"github.com/tamararankovic/hidera/config"
 	"github.com/tamararankovic/hidera/peers"
@@ -25,6 +26,13 @@ type Tree struct {
 	GlobalAgg         *Aggregate
 	ParentLastChanged int
 	CurrRound         int
+	// ValueRoundOffset is added to the rounds of the global aggregates of a
+	// root that took the tree over, so that they are newer than the ones
+	// of the previous root.
+	ValueRoundOffset int
+	// MaxMsgSize is the largest aggregate message that fits in a packet, 0
+	// if there is no limit.
+	MaxMsgSize int
 }
 
 func NewTree(params config.Params, id string, round int, ps []peers.Peer) *Tree {
@@ -48,8 +56,8 @@ func NewTree(params config.Params, id string, round int, ps []peers.Peer) *Tree
 }
 
 func (t *Tree) executeRound(currLocal Aggregate, bestTree bool) {
-	log.Printf("[EXEC ROUND] tree=%s isRoot=%t bestTree=%t localValue=%f localRound=%d",
-		t.ID, t.IsRoot, bestTree, currLocal.Value, currLocal.Round)
+	log.Printf("[EXEC ROUND] tree=%s isRoot=%t bestTree=%t localMetrics=%d localRound=%d",
+		t.ID, t.IsRoot, bestTree, len(currLocal.Metrics), currLocal.Round)
 
 	t.CurrRound = currLocal.Round
 	t.ParentLag.ForgetOlder(t.CurrRound - t.Params.Rwindow)
@@ -72,9 +80,10 @@ func (t *Tree) executeRound(currLocal Aggregate, bestTree bool) {
 func (t *Tree) sendGlobalAgg(currLocal Aggregate) {
 	if t.IsRoot {
 		log.Printf("[SEND GLOBAL AGG] tree=%s computing new GlobalAgg", t.ID)
-		ga := currLocal.Aggregate(slices.Collect(maps.Values(t.LocalAggs)))
-		t.GlobalAgg = &ga
+		ga := currLocal.Aggregate(t.childAggs())
 		t.LastGlobalRound = ga.Round
+		ga.Round += t.ValueRoundOffset
+		t.GlobalAgg = &ga
 	}
 
 	if t.GlobalAgg == nil {
@@ -84,24 +93,37 @@ func (t *Tree) sendGlobalAgg(currLocal Aggregate) {
 
 	log.Printf("[SEND GLOBAL AGG] tree=%s sending to %d children", t.ID, len(t.Children))
 
-	globalAggMsg := MsgToBytes(GlobalAggMsg{
-		TreeID:      t.ID,
-		Value:       t.GlobalAgg.Value,
-		Count:       t.GlobalAgg.Count,
-		Level:       t.Level,
-		ValueRound:  t.GlobalAgg.Round,
-		SenderRound: t.CurrRound,
+	// the root keeps only what it can send, so that all nodes agree
+	ga, globalAggMsg, err := t.fitMsg(*t.GlobalAgg, func(agg Aggregate) ([]byte, error) {
+		return EncodeMsg(GlobalAggMsg{
+			TreeID:      t.ID,
+			Metrics:     agg.Metrics,
+			Count:       agg.Count,
+			Distinct:    agg.Distinct,
+			Level:       t.Level,
+			ValueRound:  agg.Round,
+			SenderRound: t.CurrRound,
+		}, t.Params.WireFormat)
 	})
+	if err != nil {
+		log.Printf("[ERROR] Node %s tree %s cannot encode the global aggregate: %v", t.Params.ID, t.ID, err)
+		return
+	}
+	t.GlobalAgg = &ga
 	for _, p := range t.Children {
 		log.Printf("[SEND GLOBAL_AGG] tree=%s → child=%s", t.ID, p.GetID())
 		p.Send(globalAggMsg)
 	}
 
-	globalAggLazyMsg := MsgToBytes(GlobalAggLazyMsg{
+	globalAggLazyMsg, err := EncodeMsg(GlobalAggLazyMsg{
 		TreeID:      t.ID,
 		ValueRound:  t.GlobalAgg.Round,
 		SenderRound: t.CurrRound,
-	})
+	}, t.Params.WireFormat)
+	if err != nil {
+		log.Printf("[ERROR] Node %s tree %s cannot encode the lazy message: %v", t.Params.ID, t.ID, err)
+		return
+	}
 	for _, p := range t.Lazy {
 		log.Printf("[SEND GLOBAL_AGG_LAZY] tree=%s → lazy=%s", t.ID, p.GetID())
 		p.Send(globalAggLazyMsg)
@@ -111,18 +133,65 @@ func (t *Tree) sendGlobalAgg(currLocal Aggregate) {
 func (t *Tree) sendLocalAgg(currLocal Aggregate) {
 	log.Printf("[SEND LOCAL AGG] tree=%s to parent=%s", t.ID, t.Parent.GetID())
 
-	localAgg := currLocal.Aggregate(slices.Collect(maps.Values(t.LocalAggs)))
-	msg := MsgToBytes(LocalAggMsg{
-		TreeID:      t.ID,
-		Value:       localAgg.Value,
-		Count:       localAgg.Count,
-		SenderRound: localAgg.Round,
+	localAgg := currLocal.Aggregate(t.childAggs())
+	_, msg, err := t.fitMsg(localAgg, func(agg Aggregate) ([]byte, error) {
+		return EncodeMsg(LocalAggMsg{
+			TreeID:      t.ID,
+			Metrics:     agg.Metrics,
+			Count:       agg.Count,
+			Distinct:    agg.Distinct,
+			SenderRound: agg.Round,
+		}, t.Params.WireFormat)
 	})
+	if err != nil {
+		log.Printf("[ERROR] Node %s tree %s cannot encode the local aggregate: %v", t.Params.ID, t.ID, err)
+		return
+	}
 	t.Parent.Send(msg)
 }
 
+// childAggs returns the local aggregates of the children ordered by ID, so
+// that floating point sums do not depend on the order of the map.
+func (t *Tree) childAggs() []Aggregate {
+	aggs := make([]Aggregate, 0, len(t.LocalAggs))
+	for _, id := range slices.Sorted(maps.Keys(t.LocalAggs)) {
+		aggs = append(aggs, t.LocalAggs[id])
+	}
+	return aggs
+}
+
+// fitMsg drops series of the aggregate in reverse key order until its
+// message fits in a packet. The series of each node fit, but children can
+// together report more of them than any one node.
+func (t *Tree) fitMsg(agg Aggregate, encode func(Aggregate) ([]byte, error)) (Aggregate, []byte, error) {
+	msg, err := encode(agg)
+	if err != nil || t.MaxMsgSize <= 0 || len(msg) <= t.MaxMsgSize {
+		return agg, msg, err
+	}
+	keys := slices.Sorted(maps.Keys(agg.Metrics))
+	all := agg.Metrics
+	fit := sort.Search(len(keys), func(n int) bool {
+		agg.Metrics = selectMetrics(all, keys[:n+1])
+		msg, _ := encode(agg)
+		return len(msg) > t.MaxMsgSize
+	})
+	agg.Metrics = selectMetrics(all, keys[:fit])
+	log.Printf("[WARN] Node %s tree %s dropped %d of %d series from the aggregate, it does not fit in a packet",
+		t.Params.ID, t.ID, len(keys)-fit, len(keys))
+	msg, err = encode(agg)
+	return agg, msg, err
+}
+
+func selectMetrics(metrics map[string]MetricAgg, keys []string) map[string]MetricAgg {
+	selected := make(map[string]MetricAgg, len(keys))
+	for _, key := range keys {
+		selected[key] = metrics[key]
+	}
+	return selected
+}
+
 func (t *Tree) onLocalAggMsg(msg LocalAggMsg, sender peers.Peer) {
-	log.Printf("[RCV LOCAL_AGG] tree=%s from=%s value=%f round=%d", t.ID, sender.GetID(), msg.Value, msg.SenderRound)
+	log.Printf("[RCV LOCAL_AGG] tree=%s from=%s count=%d round=%d", t.ID, sender.GetID(), msg.Count, msg.SenderRound)
 
 	if t.LastRound[sender.GetID()] > msg.SenderRound {
 		log.Printf("[RCV LOCAL_AGG] tree=%s from=%s DROPPED (old round)", t.ID, sender.GetID())
@@ -140,16 +209,17 @@ func (t *Tree) onLocalAggMsg(msg LocalAggMsg, sender peers.Peer) {
 	if msg.SenderRound > t.LocalAggs[sender.GetID()].Round {
 		log.Printf("[LOCAL_AGG] tree=%s updating LocalAgg from child=%s", t.ID, sender.GetID())
 		t.LocalAggs[sender.GetID()] = Aggregate{
-			Value: msg.Value,
-			Count: msg.Count,
-			Round: msg.SenderRound,
+			Metrics:  msg.Metrics,
+			Count:    msg.Count,
+			Distinct: msg.Distinct,
+			Round:    msg.SenderRound,
 		}
 	}
 }
 
 func (t *Tree) onGlobalAggMsg(msg GlobalAggMsg, sender peers.Peer, localRound int) {
-	log.Printf("[RCV GLOBAL_AGG] tree=%s from=%s value=%f count=%d vr=%d",
-		t.ID, sender.GetID(), msg.Value, msg.Count, msg.ValueRound)
+	log.Printf("[RCV GLOBAL_AGG] tree=%s from=%s metrics=%d count=%d vr=%d",
+		t.ID, sender.GetID(), len(msg.Metrics), msg.Count, msg.ValueRound)
 
 	if t.LastRound[sender.GetID()] > msg.SenderRound {
 		log.Printf("[RCV GLOBAL_AGG] tree=%s from=%s DROPPED (old round)", t.ID, sender.GetID())
@@ -165,12 +235,13 @@ func (t *Tree) onGlobalAggMsg(msg GlobalAggMsg, sender peers.Peer, localRound in
 	}
 
 	if t.GlobalAgg == nil || msg.ValueRound > t.GlobalAgg.Round {
-		log.Printf("[GLOBAL_AGG UPDATE] tree=%s new global agg value=%f count=%d",
-			t.ID, msg.Value, msg.Count)
+		log.Printf("[GLOBAL_AGG UPDATE] tree=%s new global agg metrics=%d count=%d",
+			t.ID, len(msg.Metrics), msg.Count)
 		t.GlobalAgg = &Aggregate{
-			Value: msg.Value,
-			Count: msg.Count,
-			Round: msg.ValueRound,
+			Metrics:  msg.Metrics,
+			Count:    msg.Count,
+			Distinct: msg.Distinct,
+			Round:    msg.ValueRound,
 		}
 		t.Level = msg.Level + 1
 		t.LastGlobalRound = localRound
@@ -315,6 +386,23 @@ func (t *Tree) removeLazy(lazy peers.Peer) {
 	})
 }
 
+// replacePeer replaces the copies of the peer, for example after it moved.
+func (t *Tree) replacePeer(peer peers.Peer) {
+	if t.isParent(peer) {
+		t.Parent = &peer
+	}
+	for i := range t.Children {
+		if t.Children[i].GetID() == peer.GetID() {
+			t.Children[i] = peer
+		}
+	}
+	for i := range t.Lazy {
+		if t.Lazy[i].GetID() == peer.GetID() {
+			t.Lazy[i] = peer
+		}
+	}
+}
+
 func (t *Tree) isParent(peer peers.Peer) bool {
 	return t.Parent != nil && t.Parent.GetID() == peer.GetID()
 }
@@ -0,0 +1,57 @@
+package hidera
+
+import (
+	"testing"
+
+	"github.com/tamararankovic/hidera/config"
+	"github.com/tamararankovic/hidera/peers"
+)
+
+// peerAt returns the static peer "a" at the address.
+func peerAt(t *testing.T, addr string) peers.Peer {
+	t.Helper()
+	conf := config.Config{NodeID: "b", PeersIDs: []string{"a"}, PeersAddrs: []string{addr}}
+	ps, err := peers.NewPeersWithTransport(conf, peers.NewMemNetwork(1).Transport("b:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ps.Close() })
+	return ps.GetPeers()[0]
+}
+
+func TestReplacePeerUpdatesAddresses(t *testing.T) {
+	quietLogs(t)
+	old, moved := peerAt(t, "a:1"), peerAt(t, "a:2")
+	tests := []struct {
+		name string
+		tree func() *Tree
+		addr func(*Tree) string
+	}{
+		{name: "parent",
+			tree: func() *Tree {
+				tree := NewTree(testParams("b"), "c", 0, nil)
+				tree.Parent = &old
+				return tree
+			},
+			addr: func(tree *Tree) string { return tree.Parent.GetAddr() }},
+		{name: "child",
+			tree: func() *Tree { return NewTree(testParams("b"), "c", 0, []peers.Peer{old}) },
+			addr: func(tree *Tree) string { return tree.Children[0].GetAddr() }},
+		{name: "lazy",
+			tree: func() *Tree {
+				tree := NewTree(testParams("b"), "c", 0, nil)
+				tree.addNewLazy(old)
+				return tree
+			},
+			addr: func(tree *Tree) string { return tree.Lazy[0].GetAddr() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := tt.tree()
+			tree.replacePeer(moved)
+			if addr := tt.addr(tree); addr != "a:2" {
+				t.Fatalf("%s still at %s", tt.name, addr)
+			}
+		})
+	}
+}
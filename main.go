@@ -1,143 +1,129 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
 	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/tamararankovic/hidera/clock"
 	"github.com/tamararankovic/hidera/config"
 	"github.com/tamararankovic/hidera/hidera"
-	"github.com/tamararankovic/hidera/peers"
+	"github.com/tamararankovic/hidera/metrics"
+	"github.com/tamararankovic/hidera/scrape"
+	"github.com/tamararankovic/hidera/sink"
 )
 
 var h *hidera.Hidera
 
+var clk clock.Clock = clock.Real{}
+
 func main() {
-	time.Sleep(10 * time.Second)
+	clk.Sleep(10 * time.Second)
 
-	params := config.LoadParamsFromEnv()
 	conf := config.LoadConfigFromEnv()
-	peers, err := peers.NewPeers(conf)
+	sinks, err := sink.FromConfig(sink.LoadConfigFromEnv())
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	h, err = hidera.New(
+		hidera.WithParams(config.LoadParamsFromEnv()),
+		hidera.WithPeerConfig(conf),
+		hidera.WithSinks(sinks),
+		hidera.WithClock(clk),
+	)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-	h = hidera.NewHidera(params, peers)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	go exportAll()
+	scrapeConf := config.LoadScrapeConfigFromEnv()
+	if scrapeConf.Enabled() {
+		scraper := scrape.NewScraper(scrapeConf, ingest)
+		scraper.Clock = clk
+		go scraper.Run(ctx)
+	}
 
 	r := http.NewServeMux()
 	r.HandleFunc("POST /metrics", setMetricsHandler)
+	r.HandleFunc("POST /distinct", addDistinctHandler)
+	r.HandleFunc("GET /aggregate", getAggregateHandler)
+	r.HandleFunc("GET /trees", getTreesHandler)
+	r.HandleFunc("GET /peers", getPeersHandler)
+	r.HandleFunc("GET /metrics", getMetricsHandler)
 	log.Println("Metrics server listening on :9200/metrics")
 
 	go func() {
-		log.Fatal(http.ListenAndServe(conf.ListenIP+":9200", r))
+		log.Fatal(http.ListenAndServe(net.JoinHostPort(conf.ListenIP, "9200"), r))
 	}()
 
-	h.Run()
+	if err := h.Start(ctx); err != nil {
+		log.Fatalln(err)
+	}
 
-	<-quit
+	<-ctx.Done()
 
 	log.Println("received shutdown signal...")
-}
 
-func exportAll() {
-	for range time.NewTicker(time.Second).C {
-		value := 0.0
-		h.Lock.Lock()
-		tree := h.FindBestTree()
-		if tree != nil && tree.GlobalAgg != nil {
-			value = tree.GlobalAgg.Value / float64(tree.GlobalAgg.Count)
-		}
-		h.Lock.Unlock()
-		exportResult(float64(value), 0, time.Now().UnixNano())
-		exportMsgCount()
+	h.Stop()
+	if err := sinks.Close(); err != nil {
+		log.Println(err)
 	}
 }
 
-var writers map[string]*csv.Writer = map[string]*csv.Writer{}
-
-func exportResult(value float64, reqTimestamp, rcvTimestamp int64) {
-	filename := "/var/log/hidera/value.csv"
-	writer := writers[filename]
-	if writer == nil {
-		file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
-		if err != nil {
-			log.Printf("failed to open/create file: %v", err)
-			return
-		}
-		writer = csv.NewWriter(file)
-		writers[filename] = writer
-	}
-	defer writer.Flush()
-	reqTsStr := strconv.Itoa(int(reqTimestamp))
-	rcvTsStr := strconv.Itoa(int(rcvTimestamp))
-	valStr := strconv.FormatFloat(value, 'f', -1, 64)
-	err := writer.Write([]string{"x", reqTsStr, rcvTsStr, valStr})
+func setMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	families, err := metrics.Parse(r.Body)
 	if err != nil {
-		log.Println(err)
+		http.Error(w, "invalid metrics: "+err.Error(), http.StatusBadRequest)
+		return
 	}
+	ingest(metrics.Samples(families))
+	w.WriteHeader(http.StatusOK)
 }
 
-func exportMsgCount() {
-	filename := "/var/log/hidera/msg_count.csv"
-	writer := writers[filename]
-	if writer == nil {
-		file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
-		if err != nil {
-			log.Printf("failed to open/create file: %v", err)
-			return
+func ingest(parsed []metrics.Sample) {
+	samples := make([]hidera.Sample, 0)
+	for _, sample := range parsed {
+		// a single NaN or Inf would poison the aggregate of every node
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+			continue
 		}
-		writer = csv.NewWriter(file)
-		writers[filename] = writer
-	}
-	defer writer.Flush()
-	tsStr := strconv.Itoa(int(time.Now().UnixNano()))
-	peers.MessagesSentLock.Lock()
-	sent := peers.MessagesSent
-	peers.MessagesSentLock.Unlock()
-	peers.MessagesRcvdLock.Lock()
-	rcvd := peers.MessagesRcvd
-	peers.MessagesRcvdLock.Unlock()
-	sentStr := strconv.Itoa(sent)
-	rcvdStr := strconv.Itoa(rcvd)
-	err := writer.Write([]string{tsStr, sentStr, rcvdStr})
-	if err != nil {
-		log.Println(err)
+		samples = append(samples, hidera.Sample{
+			Name:   sample.Name,
+			Labels: sample.Labels,
+			Value:  sample.Value,
+		})
 	}
+	log.Println("new samples", len(samples))
+	h.SetSamples(samples)
 }
 
-func setMetricsHandler(w http.ResponseWriter, r *http.Request) {
-	newMetrics, err := io.ReadAll(r.Body)
+func addDistinctHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "Error reading request body", http.StatusInternalServerError)
 		return
 	}
 	defer r.Body.Close()
-	lines := strings.Split(string(newMetrics), "\n")
-	valStr := ""
-	for _, line := range lines {
-		if strings.HasPrefix(line, "app_memory_usage_bytes") {
-			valStr = strings.Split(line, " ")[1]
-			break
+	items := make([]string, 0)
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
 		}
+		items = append(items, line)
 	}
-	val, err := strconv.ParseFloat(valStr, 64)
-	if err != nil {
-		log.Println(err)
-	} else {
-		log.Println("new value", val)
-		h.Value = val
-	}
+	h.AddDistinct(items)
 	w.WriteHeader(http.StatusOK)
 }
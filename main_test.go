@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetMetricsRejectsMalformedInput(t *testing.T) {
+	for _, body := range []string{
+		"up\n",
+		"up{a=b} 1\n",
+		"# TYPE up meter\nup 1\n",
+		"# TYPE h histogram\nh_bucket 1\n",
+		"a 1\nb 1\na 2\n",
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/metrics", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		setMetricsHandler(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d for %q, want 400", rec.Code, body)
+		}
+		if !strings.HasPrefix(rec.Body.String(), "invalid metrics: ") {
+			t.Errorf("body %q for %q", rec.Body.String(), body)
+		}
+	}
+}
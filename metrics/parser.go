@@ -0,0 +1,387 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type MetricType string
+
+const (
+	Counter        MetricType = "counter"
+	Gauge          MetricType = "gauge"
+	Histogram      MetricType = "histogram"
+	GaugeHistogram MetricType = "gaugehistogram"
+	Summary        MetricType = "summary"
+	Info           MetricType = "info"
+	StateSet       MetricType = "stateset"
+	Untyped        MetricType = "untyped"
+	Unknown        MetricType = "unknown"
+)
+
+type Sample struct {
+	Name         string
+	Labels       map[string]string
+	Value        float64
+	Timestamp    float64
+	HasTimestamp bool
+}
+
+type Family struct {
+	Name    string
+	Help    string
+	Unit    string
+	Type    MetricType
+	Samples []Sample
+}
+
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Parse reads the Prometheus text exposition format, and the OpenMetrics
+// text format which extends it. Samples are grouped into the family whose
+// metadata precedes them; samples without metadata form untyped families.
+func Parse(r io.Reader) ([]Family, error) {
+	p := &parser{
+		families: make([]Family, 0),
+		seen:     make(map[string]int),
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		p.line++
+		if p.eof {
+			if strings.TrimSpace(scanner.Text()) != "" {
+				return nil, p.errorf("content after # EOF")
+			}
+			continue
+		}
+		if err := p.parseLine(scanner.Text()); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for i := range p.families {
+		if p.families[i].Type == "" {
+			p.families[i].Type = Untyped
+		}
+		if err := validateFamily(p.families[i]); err != nil {
+			return nil, err
+		}
+	}
+	return p.families, nil
+}
+
+// Samples flattens the families into their samples.
+func Samples(families []Family) []Sample {
+	samples := make([]Sample, 0)
+	for _, f := range families {
+		samples = append(samples, f.Samples...)
+	}
+	return samples
+}
+
+type parser struct {
+	line     int
+	eof      bool
+	families []Family
+	// index of a family in families by name
+	seen map[string]int
+	// index of the family samples are currently added to
+	current int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &ParseError{Line: p.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) parseLine(line string) error {
+	line = strings.TrimRight(line, " \t\r")
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+	if strings.HasPrefix(line, "#") {
+		return p.parseComment(line)
+	}
+	sample, err := p.parseSample(line)
+	if err != nil {
+		return err
+	}
+	idx := p.familyOf(sample.Name)
+	if idx < 0 {
+		if _, ok := p.seen[sample.Name]; ok {
+			return p.errorf("samples of %s are not grouped together", sample.Name)
+		}
+		p.families = append(p.families, Family{Name: sample.Name, Type: Untyped})
+		idx = len(p.families) - 1
+		p.seen[sample.Name] = idx
+		p.current = idx
+	}
+	p.families[idx].Samples = append(p.families[idx].Samples, sample)
+	return nil
+}
+
+func (p *parser) parseComment(line string) error {
+	fields := strings.Fields(strings.TrimPrefix(line, "#"))
+	if len(fields) == 1 && fields[0] == "EOF" {
+		p.eof = true
+		return nil
+	}
+	if len(fields) < 2 {
+		return nil
+	}
+	keyword, name := fields[0], fields[1]
+	if keyword != "HELP" && keyword != "TYPE" && keyword != "UNIT" {
+		return nil
+	}
+	if !isMetricName(name) {
+		return p.errorf("invalid metric name %q", name)
+	}
+	idx := p.metadataFamily(name)
+	if idx < 0 {
+		return p.errorf("metadata for %s after its samples", name)
+	}
+	f := &p.families[idx]
+	switch keyword {
+	case "HELP":
+		help := strings.TrimLeft(line[1:], " \t")[len(keyword):]
+		help = strings.TrimLeft(help, " \t")[len(name):]
+		f.Help = unescapeHelp(strings.TrimLeft(help, " \t"))
+	case "UNIT":
+		if len(fields) > 2 {
+			f.Unit = fields[2]
+		}
+	case "TYPE":
+		if len(fields) != 3 {
+			return p.errorf("invalid TYPE line for %s", name)
+		}
+		if f.Type != "" {
+			return p.errorf("duplicate TYPE for %s", name)
+		}
+		typ := MetricType(strings.ToLower(fields[2]))
+		switch typ {
+		case Counter, Gauge, Histogram, GaugeHistogram, Summary, Info, StateSet, Untyped, Unknown:
+			f.Type = typ
+		default:
+			return p.errorf("unknown metric type %q for %s", fields[2], name)
+		}
+	}
+	return nil
+}
+
+// metadataFamily returns the family metadata for name is attached to,
+// creating it if this is the first line mentioning it.
+func (p *parser) metadataFamily(name string) int {
+	idx, ok := p.seen[name]
+	if ok {
+		if idx != p.current || len(p.families[idx].Samples) > 0 {
+			return -1
+		}
+		return idx
+	}
+	p.families = append(p.families, Family{Name: name})
+	idx = len(p.families) - 1
+	p.seen[name] = idx
+	p.current = idx
+	return idx
+}
+
+// familyOf returns the index of the current family if the sample belongs to
+// it, and -1 otherwise.
+func (p *parser) familyOf(sampleName string) int {
+	if len(p.families) == 0 {
+		return -1
+	}
+	f := &p.families[p.current]
+	if sampleName == f.Name {
+		return p.current
+	}
+	suffix, ok := strings.CutPrefix(sampleName, f.Name)
+	if !ok {
+		return -1
+	}
+	allowed := []string{}
+	switch f.Type {
+	case Counter:
+		allowed = []string{"_total", "_created"}
+	case Histogram:
+		allowed = []string{"_bucket", "_sum", "_count", "_created"}
+	case GaugeHistogram:
+		allowed = []string{"_bucket", "_gsum", "_gcount"}
+	case Summary:
+		allowed = []string{"_sum", "_count", "_created"}
+	case Info:
+		allowed = []string{"_info"}
+	}
+	for _, a := range allowed {
+		if suffix == a {
+			return p.current
+		}
+	}
+	return -1
+}
+
+func (p *parser) parseSample(line string) (Sample, error) {
+	sample := Sample{Labels: map[string]string{}}
+	end := strings.IndexAny(line, "{ \t")
+	if end < 0 {
+		return sample, p.errorf("missing value")
+	}
+	sample.Name = line[:end]
+	if !isMetricName(sample.Name) {
+		return sample, p.errorf("invalid metric name %q", sample.Name)
+	}
+	rest := line[end:]
+	if strings.HasPrefix(rest, "{") {
+		var err error
+		rest, err = p.parseLabels(rest[1:], sample.Labels)
+		if err != nil {
+			return sample, err
+		}
+	}
+	// drop exemplars and trailing comments
+	if i := strings.Index(rest, "#"); i >= 0 {
+		rest = rest[:i]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return sample, p.errorf("missing value for %s", sample.Name)
+	}
+	if len(fields) > 2 {
+		return sample, p.errorf("unexpected %q after sample %s", strings.Join(fields[2:], " "), sample.Name)
+	}
+	// ParseFloat also accepts NaN, +Inf and -Inf
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return sample, p.errorf("invalid value %q for %s", fields[0], sample.Name)
+	}
+	sample.Value = value
+	if len(fields) == 2 {
+		ts, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return sample, p.errorf("invalid timestamp %q for %s", fields[1], sample.Name)
+		}
+		sample.Timestamp = ts
+		sample.HasTimestamp = true
+	}
+	return sample, nil
+}
+
+// parseLabels parses the label set after the opening brace and returns the
+// rest of the line after the closing one.
+func (p *parser) parseLabels(s string, labels map[string]string) (string, error) {
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if strings.HasPrefix(s, "}") {
+			return s[1:], nil
+		}
+		name, value, ok := strings.Cut(s, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !isLabelName(name) {
+			return "", p.errorf("invalid label name %q", name)
+		}
+		value = strings.TrimLeft(value, " \t")
+		if !strings.HasPrefix(value, `"`) {
+			return "", p.errorf("label value of %s is not quoted", name)
+		}
+		unquoted, n, err := unquoteLabel(value)
+		if err != nil {
+			return "", p.errorf("invalid value of label %s: %v", name, err)
+		}
+		if _, ok := labels[name]; ok {
+			return "", p.errorf("duplicate label %s", name)
+		}
+		labels[name] = unquoted
+		s = strings.TrimLeft(value[n:], " \t")
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		} else if !strings.HasPrefix(s, "}") {
+			return "", p.errorf("expected , or } after label %s", name)
+		}
+	}
+}
+
+// unquoteLabel decodes a quoted label value, where only \\, \" and \n are
+// valid escapes, and returns it with the number of bytes consumed.
+func unquoteLabel(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", 0, fmt.Errorf("unterminated escape")
+			}
+			switch s[i] {
+			case '\\', '"':
+				b.WriteByte(s[i])
+			case 'n':
+				b.WriteByte('\n')
+			default:
+				return "", 0, fmt.Errorf("invalid escape \\%c", s[i])
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated label value")
+}
+
+func unescapeHelp(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(s)
+}
+
+func validateFamily(f Family) error {
+	for _, s := range f.Samples {
+		switch {
+		case (f.Type == Histogram || f.Type == GaugeHistogram) && s.Name == f.Name+"_bucket":
+			if _, ok := s.Labels["le"]; !ok {
+				return fmt.Errorf("histogram %s has a bucket without le label", f.Name)
+			}
+		case f.Type == Summary && s.Name == f.Name:
+			if _, ok := s.Labels["quantile"]; !ok {
+				return fmt.Errorf("summary %s has a quantile without quantile label", f.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func isMetricName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func isLabelName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Family
+	}{
+		{
+			name:  "untyped sample",
+			input: "up 1\n",
+			want:  []Family{{Name: "up", Type: Untyped, Samples: []Sample{{Name: "up", Labels: map[string]string{}, Value: 1}}}},
+		},
+		{
+			name: "labels",
+			input: `# HELP http_requests_total Requests by code.
+# TYPE http_requests_total counter
+http_requests_total{code="200",path="/a\"b\\c\nd"} 10
+http_requests_total{ code = "500" , } 2
+`,
+			want: []Family{{Name: "http_requests_total", Help: "Requests by code.", Type: Counter, Samples: []Sample{
+				{Name: "http_requests_total", Labels: map[string]string{"code": "200", "path": "/a\"b\\c\nd"}, Value: 10},
+				{Name: "http_requests_total", Labels: map[string]string{"code": "500"}, Value: 2},
+			}}},
+		},
+		{
+			name: "timestamps",
+			input: `# TYPE temp gauge
+temp{room="a"} 21.5 1700000000000
+temp{room="b"} -3e2 1700000000.5
+`,
+			want: []Family{{Name: "temp", Type: Gauge, Samples: []Sample{
+				{Name: "temp", Labels: map[string]string{"room": "a"}, Value: 21.5, Timestamp: 1700000000000, HasTimestamp: true},
+				{Name: "temp", Labels: map[string]string{"room": "b"}, Value: -300, Timestamp: 1700000000.5, HasTimestamp: true},
+			}}},
+		},
+		{
+			name: "special values",
+			input: `# TYPE v gauge
+v{k="inf"} +Inf
+v{k="neginf"} -Inf
+`,
+			want: []Family{{Name: "v", Type: Gauge, Samples: []Sample{
+				{Name: "v", Labels: map[string]string{"k": "inf"}, Value: math.Inf(1)},
+				{Name: "v", Labels: map[string]string{"k": "neginf"}, Value: math.Inf(-1)},
+			}}},
+		},
+		{
+			name: "histogram",
+			input: `# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 3
+latency_seconds_bucket{le="+Inf"} 5
+latency_seconds_sum 1.2
+latency_seconds_count 5
+`,
+			want: []Family{{Name: "latency_seconds", Help: "Request latency.", Type: Histogram, Samples: []Sample{
+				{Name: "latency_seconds_bucket", Labels: map[string]string{"le": "0.1"}, Value: 3},
+				{Name: "latency_seconds_bucket", Labels: map[string]string{"le": "+Inf"}, Value: 5},
+				{Name: "latency_seconds_sum", Labels: map[string]string{}, Value: 1.2},
+				{Name: "latency_seconds_count", Labels: map[string]string{}, Value: 5},
+			}}},
+		},
+		{
+			name: "summary",
+			input: `# TYPE rpc_seconds summary
+rpc_seconds{quantile="0.5"} 0.01
+rpc_seconds{quantile="0.99"} 0.3
+rpc_seconds_sum 12
+rpc_seconds_count 400
+`,
+			want: []Family{{Name: "rpc_seconds", Type: Summary, Samples: []Sample{
+				{Name: "rpc_seconds", Labels: map[string]string{"quantile": "0.5"}, Value: 0.01},
+				{Name: "rpc_seconds", Labels: map[string]string{"quantile": "0.99"}, Value: 0.3},
+				{Name: "rpc_seconds_sum", Labels: map[string]string{}, Value: 12},
+				{Name: "rpc_seconds_count", Labels: map[string]string{}, Value: 400},
+			}}},
+		},
+		{
+			name: "openmetrics",
+			input: `# TYPE build info
+build_info{version="1.2"} 1
+# TYPE uptime_seconds gauge
+# UNIT uptime_seconds seconds
+uptime_seconds 30
+# TYPE requests counter
+requests_total 7 # {trace_id="abc"} 1 1700000000
+requests_created 1700000000
+# EOF
+`,
+			want: []Family{
+				{Name: "build", Type: Info, Samples: []Sample{{Name: "build_info", Labels: map[string]string{"version": "1.2"}, Value: 1}}},
+				{Name: "uptime_seconds", Unit: "seconds", Type: Gauge, Samples: []Sample{{Name: "uptime_seconds", Labels: map[string]string{}, Value: 30}}},
+				{Name: "requests", Type: Counter, Samples: []Sample{
+					{Name: "requests_total", Labels: map[string]string{}, Value: 7},
+					{Name: "requests_created", Labels: map[string]string{}, Value: 1700000000},
+				}},
+			},
+		},
+		{
+			name: "comments and blank lines",
+			input: `
+# a comment
+up 1
+
+`,
+			want: []Family{{Name: "up", Type: Untyped, Samples: []Sample{{Name: "up", Labels: map[string]string{}, Value: 1}}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNaN(t *testing.T) {
+	families, err := Parse(strings.NewReader("v NaN\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := families[0].Samples[0].Value; !math.IsNaN(v) {
+		t.Fatalf("got %g, want NaN", v)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		line  int
+	}{
+		{name: "missing value", input: "up\n", line: 1},
+		{name: "invalid value", input: "up one\n", line: 1},
+		{name: "invalid timestamp", input: "up 1 now\n", line: 1},
+		{name: "trailing fields", input: "up 1 2 3\n", line: 1},
+		{name: "invalid metric name", input: "1up 1\n", line: 1},
+		{name: "unquoted label", input: "up{a=b} 1\n", line: 1},
+		{name: "unterminated label", input: "up{a=\"b} 1\n", line: 1},
+		{name: "invalid escape", input: `up{a="\t"} 1` + "\n", line: 1},
+		{name: "duplicate label", input: `up{a="1",a="2"} 1` + "\n", line: 1},
+		{name: "missing comma", input: `up{a="1" b="2"} 1` + "\n", line: 1},
+		{name: "unknown type", input: "# TYPE up meter\nup 1\n", line: 1},
+		{name: "duplicate type", input: "# TYPE up gauge\n# TYPE up gauge\n", line: 2},
+		{name: "metadata after samples", input: "up 1\n# TYPE up gauge\n", line: 2},
+		{name: "ungrouped samples", input: "a 1\nb 1\na 2\n", line: 3},
+		{name: "content after eof", input: "up 1\n# EOF\nup 2\n", line: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.input))
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("got %v, want a parse error", err)
+			}
+			if parseErr.Line != tt.line {
+				t.Fatalf("error at line %d, want %d: %v", parseErr.Line, tt.line, err)
+			}
+		})
+	}
+}
+
+func TestParseInvalidFamilies(t *testing.T) {
+	for _, input := range []string{
+		"# TYPE h histogram\nh_bucket 1\n",
+		"# TYPE s summary\ns 1\n",
+	} {
+		if _, err := Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("no error for %q", input)
+		}
+	}
+}
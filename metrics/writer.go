@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Write encodes the families in the Prometheus text exposition format.
+func Write(w io.Writer, families []Family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		if f.Help != "" {
+			bw.WriteString("# HELP " + f.Name + " " + escapeHelp(f.Help) + "\n")
+		}
+		if f.Type != "" {
+			bw.WriteString("# TYPE " + f.Name + " " + string(f.Type) + "\n")
+		}
+		for _, s := range f.Samples {
+			bw.WriteString(s.Name)
+			if len(s.Labels) > 0 {
+				bw.WriteString("{")
+				for i, name := range slices.Sorted(maps.Keys(s.Labels)) {
+					if i > 0 {
+						bw.WriteString(",")
+					}
+					bw.WriteString(name + `="` + escapeLabel(s.Labels[name]) + `"`)
+				}
+				bw.WriteString("}")
+			}
+			bw.WriteString(" " + formatFloat(s.Value))
+			if s.HasTimestamp {
+				bw.WriteString(" " + strconv.FormatFloat(s.Timestamp, 'f', -1, 64))
+			}
+			bw.WriteString("\n")
+		}
+	}
+	return bw.Flush()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
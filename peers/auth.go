@@ -0,0 +1,186 @@
+package peers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	macSize      = sha256.Size
+	seqSize      = 8
+	timeSize     = 8
+	replayWindow = 64
+	// maxClockSkew is how far behind the receiver's clock a sender's clock
+	// may be without its first packets after the receiver starts being
+	// rejected as too old
+	maxClockSkew = 2 * time.Second
+)
+
+var (
+	errShortPacket = errors.New("packet too short")
+	errBadMAC      = errors.New("invalid MAC")
+	errReplay      = errors.New("replayed or too old packet")
+)
+
+// authenticator signs datagrams with HMAC-SHA256 over a shared cluster key.
+// A signed datagram is [len(id)][id][seq][time][payload][mac]. The MAC also
+// covers the ID of the destination, which is not sent, so a datagram is only
+// valid for the node it was sent to. Datagrams to seeds, whose IDs are not
+// known yet, are signed for an empty destination and only accepted as joins.
+//
+// The sequence number starts at the node's start time, so it keeps growing
+// across restarts, and receivers reject sequence numbers they have seen or
+// that are older than their replay window. Since receivers forget the
+// windows when they restart, they also reject datagrams whose send time is
+// older than their own start, so datagrams recorded before a restart cannot
+// be replayed after it.
+//
+// Keys are rotated by restarting every node with the new key and the old
+// one as prevKey. Until the grace period ends, nodes keep signing with the
+// old key and accept both, so that nodes that were not restarted yet accept
+// them. The rotation has to be finished within the grace period of the
+// first restarted node.
+type authenticator struct {
+	id         string
+	key        []byte
+	prevKey    []byte
+	prevExpiry time.Time
+	start      uint64
+	seq        atomic.Uint64
+	windows    map[string]*window
+	lock       *sync.Mutex
+}
+
+func newAuthenticator(id string, key, prevKey []byte, grace time.Duration) *authenticator {
+	now := time.Now()
+	a := &authenticator{
+		id:         id,
+		key:        key,
+		prevKey:    prevKey,
+		prevExpiry: now.Add(grace),
+		start:      uint64(now.Add(-maxClockSkew).UnixNano()),
+		windows:    make(map[string]*window),
+		lock:       new(sync.Mutex),
+	}
+	a.seq.Store(uint64(now.UnixNano()))
+	return a
+}
+
+// seal signs a payload for the node dst, which is empty if its ID is not
+// known.
+func (a *authenticator) seal(dst string, payload []byte) []byte {
+	b := make([]byte, 0, 1+len(a.id)+seqSize+timeSize+len(payload)+macSize)
+	b = append(b, byte(len(a.id)))
+	b = append(b, a.id...)
+	b = binary.BigEndian.AppendUint64(b, a.seq.Add(1))
+	b = binary.BigEndian.AppendUint64(b, uint64(time.Now().UnixNano()))
+	b = append(b, payload...)
+	return append(b, sign(a.signingKey(), dst, b)...)
+}
+
+func (a *authenticator) signingKey() []byte {
+	if a.prevKey != nil && time.Now().Before(a.prevExpiry) {
+		return a.prevKey
+	}
+	return a.key
+}
+
+// overhead is the size seal adds to a payload.
+func (a *authenticator) overhead() int {
+	return 1 + len(a.id) + seqSize + timeSize + macSize
+}
+
+// open verifies a datagram and returns the sender ID it carries and its
+// payload. anyDst is set if the datagram was signed for an empty
+// destination.
+func (a *authenticator) open(data []byte) (id string, payload []byte, anyDst bool, err error) {
+	if len(data) < 1 || len(data) < 1+int(data[0])+seqSize+timeSize+macSize {
+		return "", nil, false, errShortPacket
+	}
+	signed, mac := data[:len(data)-macSize], data[len(data)-macSize:]
+	if !a.verify(a.id, signed, mac) {
+		if !a.verify("", signed, mac) {
+			return "", nil, false, errBadMAC
+		}
+		anyDst = true
+	}
+	idLen := int(data[0])
+	id = string(data[1 : 1+idLen])
+	seq := binary.BigEndian.Uint64(data[1+idLen:])
+	if sent := binary.BigEndian.Uint64(data[1+idLen+seqSize:]); sent < a.start {
+		return "", nil, false, errReplay
+	}
+	a.lock.Lock()
+	w := a.windows[id]
+	if w == nil {
+		w = &window{}
+		a.windows[id] = w
+	}
+	fresh := w.accept(seq)
+	a.lock.Unlock()
+	if !fresh {
+		return "", nil, false, errReplay
+	}
+	return id, signed[1+idLen+seqSize+timeSize:], anyDst, nil
+}
+
+func (a *authenticator) verify(dst string, signed, mac []byte) bool {
+	if hmac.Equal(mac, sign(a.key, dst, signed)) {
+		return true
+	}
+	return a.prevKey != nil && time.Now().Before(a.prevExpiry) && hmac.Equal(mac, sign(a.prevKey, dst, signed))
+}
+
+func sign(key []byte, dst string, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte{byte(len(dst))})
+	mac.Write([]byte(dst))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// window is a sliding replay window over the last replayWindow sequence
+// numbers, bit i of seen is set if max-i was received.
+type window struct {
+	max  uint64
+	seen uint64
+}
+
+func (w *window) accept(seq uint64) bool {
+	if seq > w.max {
+		shift := seq - w.max
+		if shift >= replayWindow {
+			w.seen = 0
+		} else {
+			w.seen <<= shift
+		}
+		w.seen |= 1
+		w.max = seq
+		return true
+	}
+	diff := w.max - seq
+	if diff >= replayWindow || w.seen&(1<<diff) != 0 {
+		return false
+	}
+	w.seen |= 1 << diff
+	return true
+}
+
+func rejectReason(err error) string {
+	switch err {
+	case errShortPacket:
+		return "short"
+	case errBadMAC:
+		return "bad_mac"
+	case errReplay:
+		return "replay"
+	case errDecrypt:
+		return "decrypt"
+	}
+	return "other"
+}
@@ -0,0 +1,129 @@
+package peers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hidera/config"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestAuthenticatorOpensSealed(t *testing.T) {
+	a := newAuthenticator("a", testKey, nil, 0)
+	b := newAuthenticator("b", testKey, nil, 0)
+	id, payload, anyDst, err := b.open(a.seal("b", []byte("hello")))
+	if err != nil || id != "a" || string(payload) != "hello" || anyDst {
+		t.Fatalf("got %q %q %t %v", id, payload, anyDst, err)
+	}
+}
+
+func TestAuthenticatorRejectsOtherDestination(t *testing.T) {
+	a := newAuthenticator("a", testKey, nil, 0)
+	c := newAuthenticator("c", testKey, nil, 0)
+	if _, _, _, err := c.open(a.seal("b", []byte("hello"))); err != errBadMAC {
+		t.Fatalf("got %v, want %v", err, errBadMAC)
+	}
+	_, _, anyDst, err := c.open(a.seal("", []byte("join")))
+	if err != nil || !anyDst {
+		t.Fatalf("datagram without destination: anyDst %t, error %v", anyDst, err)
+	}
+}
+
+func TestAuthenticatorRejectsWrongKey(t *testing.T) {
+	a := newAuthenticator("a", []byte("another key"), nil, 0)
+	b := newAuthenticator("b", testKey, nil, 0)
+	if _, _, _, err := b.open(a.seal("b", []byte("hello"))); err != errBadMAC {
+		t.Fatalf("got %v, want %v", err, errBadMAC)
+	}
+	rotated := newAuthenticator("b", testKey, []byte("another key"), time.Minute)
+	if _, _, _, err := rotated.open(a.seal("b", []byte("hello"))); err != nil {
+		t.Fatalf("previous key rejected during the grace period: %v", err)
+	}
+}
+
+func TestAuthenticatorKeyRotation(t *testing.T) {
+	oldKey := []byte("another key")
+	old := newAuthenticator("a", oldKey, nil, 0)
+	rotated := newAuthenticator("b", testKey, oldKey, time.Minute)
+	if _, _, _, err := old.open(rotated.seal("a", []byte("hello"))); err != nil {
+		t.Fatalf("node with the previous key rejected a rotated node during the grace period: %v", err)
+	}
+	if _, _, _, err := rotated.open(old.seal("b", []byte("hello"))); err != nil {
+		t.Fatalf("rotated node rejected the previous key during the grace period: %v", err)
+	}
+	if _, _, _, err := rotated.open(newAuthenticator("c", testKey, nil, 0).seal("b", []byte("hello"))); err != nil {
+		t.Fatalf("rotated node rejected the new key during the grace period: %v", err)
+	}
+
+	rotated.prevExpiry = time.Now()
+	if _, _, _, err := old.open(rotated.seal("a", []byte("hello"))); err != errBadMAC {
+		t.Fatalf("got %v after the grace period, want the new key only", err)
+	}
+	if _, _, _, err := rotated.open(old.seal("b", []byte("hello"))); err != errBadMAC {
+		t.Fatalf("got %v for the previous key after the grace period, want %v", err, errBadMAC)
+	}
+}
+
+func TestAuthenticatorRejectsReplays(t *testing.T) {
+	a := newAuthenticator("a", testKey, nil, 0)
+	b := newAuthenticator("b", testKey, nil, 0)
+	first := a.seal("b", []byte("1"))
+	second := a.seal("b", []byte("2"))
+	if _, _, _, err := b.open(second); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := b.open(first); err != nil {
+		t.Fatalf("reordered datagram rejected: %v", err)
+	}
+	if _, _, _, err := b.open(second); err != errReplay {
+		t.Fatalf("got %v for a replay, want %v", err, errReplay)
+	}
+}
+
+func TestAuthenticatorRejectsDatagramsFromBeforeStart(t *testing.T) {
+	a := newAuthenticator("a", testKey, nil, 0)
+	recorded := a.seal("b", []byte("hello"))
+	// b restarts after the datagram was recorded
+	b := newAuthenticator("b", testKey, nil, 0)
+	b.start = uint64(time.Now().UnixNano())
+	if _, _, _, err := b.open(recorded); err != errReplay {
+		t.Fatalf("got %v, want %v", err, errReplay)
+	}
+	if _, _, _, err := b.open(a.seal("b", []byte("hello"))); err != nil {
+		t.Fatalf("datagram sent after the start rejected: %v", err)
+	}
+}
+
+func TestPeerAddressOnlyMovesOnDataPackets(t *testing.T) {
+	encKey := []byte("0123456789abcdef")
+	conf := config.Config{NodeID: "b", PeersIDs: []string{"a"}, PeersAddrs: []string{"a:1"}, ClusterKey: testKey, PeersKeys: [][]byte{encKey}}
+	ps, err := NewPeersWithTransport(conf, NewMemNetwork(1).Transport("b:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+	aead, _ := newAEAD(encKey)
+	nonces := newNonceSource("a")
+	a := newAuthenticator("a", testKey, nil, 0)
+	addr := func() string { return ps.findPeerById("a").addr }
+
+	ps.Receive(Packet{From: "a:2", Data: a.seal("b", []byte("not encrypted"))})
+	if addr() != "a:1" {
+		t.Fatalf("undecryptable packet moved the peer to %s", addr())
+	}
+	ps.Receive(Packet{From: "a:2", Data: a.seal("b", encrypt(aead, nonces, rawControlMsg(DISCONNECT_MSG_TYPE, controlMsg{ID: "a"})))})
+	if addr() != "a:1" {
+		t.Fatalf("control message moved the peer to %s", addr())
+	}
+	_, change, ok := ps.Receive(Packet{From: "a:2", Data: a.seal("b", encrypt(aead, nonces, []byte{1}))})
+	if !ok {
+		t.Fatal("data packet rejected")
+	}
+	if addr() != "a:2" {
+		t.Fatalf("peer at %s after a data packet from a:2", addr())
+	}
+	if len(change.Moved) != 1 || change.Moved[0].GetAddr() != "a:2" {
+		t.Fatalf("move not reported in %+v", change)
+	}
+}
@@ -0,0 +1,74 @@
+package peers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// MaxDatagramSize keeps datagrams within a 1500 byte Ethernet MTU.
+const MaxDatagramSize = 1472
+
+const noncePrefixSize = 4
+
+var errDecrypt = errors.New("decryption failed")
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonceSource makes the nonces of one node. A nonce is [prefix][counter],
+// the prefix is derived from the node ID, so nodes that share a key use
+// different nonces, and the counter starts at the node's start time, so a
+// restarted node does not repeat the nonces it used before.
+type nonceSource struct {
+	prefix  [noncePrefixSize]byte
+	counter atomic.Uint64
+}
+
+func newNonceSource(id string) *nonceSource {
+	n := &nonceSource{}
+	hash := sha256.Sum256([]byte(id))
+	copy(n.prefix[:], hash[:])
+	n.counter.Store(uint64(time.Now().UnixNano()))
+	return n
+}
+
+func (n *nonceSource) next() []byte {
+	nonce := make([]byte, 0, noncePrefixSize+8)
+	nonce = append(nonce, n.prefix[:]...)
+	return binary.BigEndian.AppendUint64(nonce, n.counter.Add(1))
+}
+
+// encrypt returns [nonce][ciphertext][tag].
+func encrypt(aead cipher.AEAD, nonces *nonceSource, plaintext []byte) []byte {
+	nonce := nonces.next()
+	out := make([]byte, 0, len(nonce)+len(plaintext)+aead.Overhead())
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, nil)
+}
+
+// aeadOverhead is the size encrypt adds to a plaintext.
+func aeadOverhead(aead cipher.AEAD) int {
+	return aead.NonceSize() + aead.Overhead()
+}
+
+func decrypt(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, errShortPacket
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errDecrypt
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,47 @@
+package peers
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNoncesAreUniquePerNode(t *testing.T) {
+	a, b := newNonceSource("a"), newNonceSource("b")
+	if a.prefix == b.prefix {
+		t.Fatal("nodes a and b share a nonce prefix")
+	}
+	seen := make(map[string]bool)
+	for range 1000 {
+		for _, nonce := range [][]byte{a.next(), b.next()} {
+			if seen[string(nonce)] {
+				t.Fatalf("nonce %x repeated", nonce)
+			}
+			seen[string(nonce)] = true
+		}
+	}
+	// a restarted node continues after the nonces it used before
+	before := a.next()
+	if restarted := newNonceSource("a").next(); bytes.Compare(restarted, before) <= 0 {
+		t.Fatalf("nonce %x after a restart is not after %x", restarted, before)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	aead, err := newAEAD([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonces := newNonceSource("a")
+	data := encrypt(aead, nonces, []byte("hello"))
+	if len(data) != len("hello")+aeadOverhead(aead) {
+		t.Fatalf("%d bytes, want %d", len(data), len("hello")+aeadOverhead(aead))
+	}
+	plaintext, err := decrypt(aead, data)
+	if err != nil || string(plaintext) != "hello" {
+		t.Fatalf("got %q, %v", plaintext, err)
+	}
+	data[len(data)-1] ^= 1
+	if _, err := decrypt(aead, data); err != errDecrypt {
+		t.Fatalf("got %v for a tampered packet, want %v", err, errDecrypt)
+	}
+}
@@ -0,0 +1,161 @@
+package peers
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/tamararankovic/hidera/clock"
+)
+
+const memQueueSize = 1024
+
+// MemNetwork connects MemTransports in the same process through channels,
+// so that several nodes can be run in one test. Latency is drawn uniformly
+// from [MinLatency, MaxLatency] for every packet, packets are lost with
+// probability Loss, and with probability Reorder a packet is held back for an
+// extra MaxLatency so that it arrives after packets sent later. Delays are
+// timed by Clock, with a fake clock packets arrive when it is advanced.
+type MemNetwork struct {
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	Loss       float64
+	Reorder    float64
+	Clock      clock.Clock
+	nodes      map[string]*MemTransport
+	partition  map[string]int
+	bytesSent  int
+	rand       *rand.Rand
+	lock       *sync.Mutex
+}
+
+// MemTransport queues the packets it receives for Packets. If Handler is
+// set, packets are passed to it instead, on the goroutine that runs the
+// timers of the clock, so that a whole cluster can run on one goroutine.
+type MemTransport struct {
+	Handler func(Packet)
+	addr    string
+	network *MemNetwork
+	packets chan Packet
+	closed  bool
+}
+
+func NewMemNetwork(seed int64) *MemNetwork {
+	return &MemNetwork{
+		Clock:     clock.Real{},
+		nodes:     make(map[string]*MemTransport),
+		partition: make(map[string]int),
+		rand:      rand.New(rand.NewSource(seed)),
+		lock:      new(sync.Mutex),
+	}
+}
+
+// Transport attaches a node with the given "host:port" address.
+func (n *MemNetwork) Transport(addr string) *MemTransport {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	t := &MemTransport{
+		addr:    addr,
+		network: n,
+		packets: make(chan Packet, memQueueSize),
+	}
+	n.nodes[addr] = t
+	return t
+}
+
+// Partition splits the network, packets are only delivered between
+// addresses in the same group. Addresses not in any group form one more
+// group together.
+func (n *MemNetwork) Partition(groups ...[]string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.partition = make(map[string]int)
+	for i, group := range groups {
+		for _, addr := range group {
+			n.partition[addr] = i + 1
+		}
+	}
+}
+
+func (n *MemNetwork) Heal() {
+	n.Partition()
+}
+
+// BytesSent counts the bytes of all packets sent, including lost ones.
+func (n *MemNetwork) BytesSent() int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.bytesSent
+}
+
+func (n *MemNetwork) send(from, to string, data []byte) {
+	n.lock.Lock()
+	n.bytesSent += len(data)
+	dst := n.nodes[to]
+	if dst == nil || n.partition[from] != n.partition[to] || n.rand.Float64() < n.Loss {
+		n.lock.Unlock()
+		return
+	}
+	delay := n.MinLatency
+	if n.MaxLatency > n.MinLatency {
+		delay += time.Duration(n.rand.Int63n(int64(n.MaxLatency - n.MinLatency)))
+	}
+	if n.rand.Float64() < n.Reorder {
+		delay += n.MaxLatency
+	}
+	// a handler may send from within the call, so it never runs on the
+	// sender's goroutine
+	handled := dst.Handler != nil
+	n.lock.Unlock()
+
+	packet := Packet{From: from, Data: append([]byte(nil), data...)}
+	if delay <= 0 && !handled {
+		dst.deliver(packet)
+		return
+	}
+	n.Clock.AfterFunc(delay, func() { dst.deliver(packet) })
+}
+
+func (t *MemTransport) deliver(packet Packet) {
+	t.network.lock.Lock()
+	if t.closed {
+		t.network.lock.Unlock()
+		return
+	}
+	if handler := t.Handler; handler != nil {
+		t.network.lock.Unlock()
+		handler(packet)
+		return
+	}
+	defer t.network.lock.Unlock()
+	select {
+	case t.packets <- packet:
+	default:
+		countDropped("queue_full")
+	}
+}
+
+func (t *MemTransport) Send(addr string, data []byte) error {
+	t.network.send(t.addr, addr, data)
+	return nil
+}
+
+func (t *MemTransport) Packets() <-chan Packet {
+	return t.packets
+}
+
+func (t *MemTransport) MaxPacketSize() int {
+	return MaxDatagramSize
+}
+
+func (t *MemTransport) Close() error {
+	t.network.lock.Lock()
+	defer t.network.lock.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	delete(t.network.nodes, t.addr)
+	close(t.packets)
+	return nil
+}
@@ -0,0 +1,75 @@
+package peers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hidera/clock"
+)
+
+func TestMemNetworkDeliversOnClock(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	network := NewMemNetwork(1)
+	network.Clock = c
+	network.MinLatency = 10 * time.Millisecond
+	network.MaxLatency = 20 * time.Millisecond
+	a := network.Transport("a:1")
+	b := network.Transport("b:1")
+	var got []Packet
+	b.Handler = func(p Packet) { got = append(got, p) }
+
+	a.Send("b:1", []byte("hello"))
+	c.Advance(9 * time.Millisecond)
+	if len(got) != 0 {
+		t.Fatalf("delivered after 9ms, before the minimum latency")
+	}
+	c.Advance(11 * time.Millisecond)
+	if len(got) != 1 || got[0].From != "a:1" || string(got[0].Data) != "hello" {
+		t.Fatalf("got %+v, want one packet from a:1", got)
+	}
+	if network.BytesSent() != 5 {
+		t.Fatalf("bytes sent %d, want 5", network.BytesSent())
+	}
+}
+
+func TestMemNetworkPartition(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	network := NewMemNetwork(1)
+	network.Clock = c
+	a := network.Transport("a:1")
+	b := network.Transport("b:1")
+	received := 0
+	b.Handler = func(Packet) { received++ }
+
+	network.Partition([]string{"a:1"})
+	a.Send("b:1", []byte("lost"))
+	c.Advance(time.Second)
+	if received != 0 {
+		t.Fatalf("packet crossed the partition")
+	}
+	network.Heal()
+	a.Send("b:1", []byte("delivered"))
+	c.Advance(time.Second)
+	if received != 1 {
+		t.Fatalf("received %d packets after healing, want 1", received)
+	}
+}
+
+func TestMemTransportQueuesWithoutHandler(t *testing.T) {
+	network := NewMemNetwork(1)
+	a := network.Transport("a:1")
+	b := network.Transport("b:1")
+	a.Send("b:1", []byte("hello"))
+	select {
+	case p := <-b.Packets():
+		if string(p.Data) != "hello" {
+			t.Fatalf("got %q", p.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("packet not delivered")
+	}
+	b.Close()
+	if _, ok := <-b.Packets(); ok {
+		t.Fatal("packets channel open after Close")
+	}
+}
@@ -0,0 +1,488 @@
+package peers
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"slices"
+)
+
+// Message types from CONTROL_MSG_TYPE up belong to the peer layer, they are
+// handled by Peers and never passed on to the protocol.
+const CONTROL_MSG_TYPE byte = 0x40
+
+const (
+	JOIN_MSG_TYPE           byte = 0x40
+	JOIN_REPLY_MSG_TYPE     byte = 0x41
+	FORWARD_JOIN_MSG_TYPE   byte = 0x42
+	NEIGHBOR_MSG_TYPE       byte = 0x43
+	NEIGHBOR_REPLY_MSG_TYPE byte = 0x44
+	DISCONNECT_MSG_TYPE     byte = 0x45
+	SHUFFLE_MSG_TYPE        byte = 0x46
+	SHUFFLE_REPLY_MSG_TYPE  byte = 0x47
+	PROBE_MSG_TYPE          byte = 0x48
+	PROBE_ACK_MSG_TYPE      byte = 0x49
+	PING_REQ_MSG_TYPE       byte = 0x4a
+)
+
+// The overlay follows HyParView: the active view holds the peers the
+// protocol talks to, the passive view holds backup peers that replace
+// failed ones. Walk lengths and shuffle sizes are the ones from the paper,
+// timings are counted in calls to Maintain, which hidera makes every round.
+const (
+	defaultActiveSize  = 4
+	passiveSizeFactor  = 6
+	activeWalkLength   = 6
+	passiveWalkLength  = 3
+	shuffleInterval    = 10
+	shuffleActive      = 3
+	shufflePassive     = 4
+	neighborTimeout    = 3
+	failedPeerLifetime = 30
+)
+
+type controlMsg struct {
+	ID          string
+	Joiner      *viewEntry  `json:",omitempty"`
+	TTL         int         `json:",omitempty"`
+	High        bool        `json:",omitempty"`
+	Accept      bool        `json:",omitempty"`
+	Entries     []viewEntry `json:",omitempty"`
+	Target      *viewEntry  `json:",omitempty"`
+	Origin      *viewEntry  `json:",omitempty"`
+	Incarnation uint64      `json:",omitempty"`
+}
+
+type viewEntry struct {
+	ID   string
+	Addr string
+}
+
+// ViewChange lists the peers that entered and left the active view.
+// Failed peers were suspected and did not answer the probes, they are only
+// reported by Maintain. Alive peers were suspected and did answer.
+type ViewChange struct {
+	Added   []Peer
+	Removed []Peer
+	Failed  []Peer
+	Alive   []Peer
+	// Moved are peers that send from a new address, copies of them held
+	// elsewhere have to be replaced to reach them
+	Moved []Peer
+}
+
+func (c *ViewChange) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Failed) == 0 && len(c.Alive) == 0 && len(c.Moved) == 0
+}
+
+func (c *ViewChange) add(p Peer) {
+	c.Added = append(c.Added, p)
+}
+
+func (c *ViewChange) remove(p Peer) {
+	c.Removed = append(c.Removed, p)
+}
+
+func (c *ViewChange) fail(p Peer) {
+	c.Failed = append(c.Failed, p)
+}
+
+func (c *ViewChange) alive(p Peer) {
+	c.Alive = append(c.Alive, p)
+}
+
+func (c *ViewChange) move(p Peer) {
+	c.Moved = append(c.Moved, p)
+}
+
+func isControlMsg(data []byte) bool {
+	return len(data) > 0 && data[0] >= CONTROL_MSG_TYPE
+}
+
+func (ps *Peers) controlMsg(msgType byte, msg controlMsg) []byte {
+	msg.ID = ps.id
+	msgBytes, _ := json.Marshal(msg)
+	return append([]byte{msgType}, msgBytes...)
+}
+
+// Maintain keeps the active view full. A node without any peers joins
+// through the seeds, otherwise passive peers are asked to replace failed
+// ones, and every shuffleInterval calls the passive view is refreshed.
+func (ps *Peers) Maintain() ViewChange {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	var change ViewChange
+	ps.ticks++
+
+	for id, age := range ps.pendingNeighbors {
+		if age >= neighborTimeout {
+			delete(ps.pendingNeighbors, id)
+			ps.removePassive(id)
+			continue
+		}
+		ps.pendingNeighbors[id] = age + 1
+	}
+
+	for i := 0; i < len(ps.peers); i++ {
+		p := &ps.peers[i]
+		if !p.failed {
+			p.failedTicks = 0
+			continue
+		}
+		p.failedTicks++
+		if p.failedTicks > failedPeerLifetime && !p.static {
+			log.Printf("forgetting failed peer %s", p.id)
+			change.remove(*p)
+			ps.peers = slices.Delete(ps.peers, i, i+1)
+			i--
+		}
+	}
+
+	ps.checkSuspects(&change)
+
+	active := ps.activeCount()
+	for active > ps.activeSize {
+		p, ok := ps.dropRandomActive("")
+		if !ok {
+			break
+		}
+		change.remove(p)
+		active--
+	}
+
+	if active == 0 && len(ps.passive) == 0 && len(ps.pendingNeighbors) == 0 {
+		msg := ps.controlMsg(JOIN_MSG_TYPE, controlMsg{})
+		for _, seed := range ps.seeds {
+			ps.sendTo("", seed, msg)
+		}
+	}
+	if active+len(ps.pendingNeighbors) < ps.activeSize {
+		candidates := slices.DeleteFunc(slices.Clone(ps.passive), func(e viewEntry) bool {
+			_, pending := ps.pendingNeighbors[e.ID]
+			return pending || ps.findPeerById(e.ID) != nil
+		})
+		if len(candidates) > 0 {
+			e := candidates[ps.Rand.Intn(len(candidates))]
+			ps.pendingNeighbors[e.ID] = 0
+			ps.sendTo(e.ID, e.Addr, ps.controlMsg(NEIGHBOR_MSG_TYPE, controlMsg{High: active == 0}))
+		}
+	}
+
+	if ps.ticks%shuffleInterval == 0 {
+		ps.shuffle()
+	}
+	return change
+}
+
+func (ps *Peers) shuffle() {
+	active := ps.activePeers()
+	if len(active) == 0 {
+		return
+	}
+	entries := []viewEntry{{ID: ps.id}}
+	for _, i := range ps.Rand.Perm(len(active))[:min(shuffleActive, len(active))] {
+		entries = append(entries, viewEntry{ID: active[i].id, Addr: active[i].addr})
+	}
+	for _, i := range ps.Rand.Perm(len(ps.passive))[:min(shufflePassive, len(ps.passive))] {
+		entries = append(entries, ps.passive[i])
+	}
+	target := active[ps.Rand.Intn(len(active))]
+	target.Send(ps.controlMsg(SHUFFLE_MSG_TYPE, controlMsg{TTL: activeWalkLength, Entries: entries}))
+}
+
+// AddPeer adds a peer to the active view at runtime, or recovers it if it
+// is known and failed. The change holds the peer if it is new or was
+// recovered and the peer dropped to make room for it, if any, and is to be
+// applied like the ones on ViewChanges, e.g. with Hidera.HandleViewChange.
+func (ps *Peers) AddPeer(id, addr string) ViewChange {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	var change ViewChange
+	ps.addActive(id, addr, &change)
+	return change
+}
+
+// RemovePeer removes a peer from the active view without telling it. The
+// change holds the peer if it was active and is to be applied like the ones
+// on ViewChanges.
+func (ps *Peers) RemovePeer(id string) ViewChange {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	var change ViewChange
+	for i := range ps.peers {
+		if ps.peers[i].id == id {
+			change.remove(ps.peers[i])
+			ps.peers = slices.Delete(ps.peers, i, i+1)
+			break
+		}
+	}
+	return change
+}
+
+// addActive adds a peer to the active view and removes it from the passive
+// one. If the active view is full, a random peer is disconnected to make
+// room.
+func (ps *Peers) addActive(id, addr string, change *ViewChange) (Peer, bool) {
+	if id == ps.id {
+		return Peer{}, false
+	}
+	ps.removePassive(id)
+	delete(ps.pendingNeighbors, id)
+	if p := ps.findPeerById(id); p != nil {
+		recovered := p.failed
+		p.failed = false
+		p.addr = addr
+		if recovered {
+			change.add(*p)
+		}
+		return *p, recovered
+	}
+	if ps.activeCount() >= ps.activeSize {
+		if p, ok := ps.dropRandomActive(id); ok {
+			change.remove(p)
+		}
+	}
+	log.Printf("adding peer %s at %s", id, addr)
+	p := Peer{
+		id:        id,
+		addr:      addr,
+		transport: ps.transport,
+		auth:      ps.auth,
+		aead:      ps.aead,
+		nonces:    ps.nonces,
+		failed:    false,
+	}
+	ps.peers = append(ps.peers, p)
+	change.add(p)
+	return p, true
+}
+
+// dropRandomActive disconnects a random active peer other than except and
+// moves it to the passive view. Static peers are never dropped, ok is false
+// if there is no other peer.
+func (ps *Peers) dropRandomActive(except string) (Peer, bool) {
+	candidates := make([]int, 0)
+	for i, p := range ps.peers {
+		if !p.failed && !p.static && p.id != except {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return Peer{}, false
+	}
+	i := candidates[ps.Rand.Intn(len(candidates))]
+	p := ps.peers[i]
+	log.Printf("disconnecting peer %s", p.id)
+	p.Send(ps.controlMsg(DISCONNECT_MSG_TYPE, controlMsg{}))
+	ps.peers = slices.Delete(ps.peers, i, i+1)
+	ps.addPassive(viewEntry{ID: p.id, Addr: p.addr})
+	return p, true
+}
+
+func (ps *Peers) addPassive(e viewEntry) {
+	if e.ID == ps.id || e.Addr == "" || ps.findPeerById(e.ID) != nil {
+		return
+	}
+	if slices.ContainsFunc(ps.passive, func(p viewEntry) bool { return p.ID == e.ID }) {
+		return
+	}
+	if len(ps.passive) >= ps.passiveSize {
+		i := ps.Rand.Intn(len(ps.passive))
+		ps.passive = slices.Delete(ps.passive, i, i+1)
+	}
+	ps.passive = append(ps.passive, e)
+}
+
+func (ps *Peers) removePassive(id string) {
+	ps.passive = slices.DeleteFunc(ps.passive, func(e viewEntry) bool { return e.ID == id })
+}
+
+// sendTo sends to a node that is not necessarily a peer yet, id is empty
+// if it is not known. Peers are sent to with their own key, since that is
+// the key they decrypt with.
+func (ps *Peers) sendTo(id, addr string, data []byte) {
+	p := Peer{id: id, addr: addr, transport: ps.transport, auth: ps.auth, aead: ps.aead, nonces: ps.nonces}
+	if known := ps.findPeerById(id); id != "" && known != nil {
+		p.aead = known.aead
+	}
+	p.Send(data)
+}
+
+func (ps *Peers) activePeers() []Peer {
+	active := make([]Peer, 0)
+	for _, p := range ps.peers {
+		if !p.failed {
+			active = append(active, p)
+		}
+	}
+	return active
+}
+
+func (ps *Peers) activeCount() int {
+	return len(ps.activePeers())
+}
+
+// randomActive returns a random active peer other than except.
+func (ps *Peers) randomActive(except string) *Peer {
+	candidates := slices.DeleteFunc(ps.activePeers(), func(p Peer) bool { return p.id == except })
+	if len(candidates) == 0 {
+		return nil
+	}
+	return &candidates[ps.Rand.Intn(len(candidates))]
+}
+
+// activeSender returns the active peer that sent a message, matched by the
+// signed ID if there is one and by the address otherwise, or nil.
+func (ps *Peers) activeSender(from, authID string) *Peer {
+	var p *Peer
+	if authID != "" {
+		p = ps.findPeerById(authID)
+	} else {
+		p = ps.findPeerByAddr(from)
+	}
+	if p == nil || p.failed {
+		return nil
+	}
+	return p
+}
+
+// handleControl handles a control message from the address from. authID is
+// the sender ID from the signature, or empty if messages are not signed.
+func (ps *Peers) handleControl(from, authID string, payload []byte) ViewChange {
+	var change ViewChange
+	if _, _, err := net.SplitHostPort(from); err != nil {
+		countRejected("malformed_control")
+		return change
+	}
+	var msg controlMsg
+	if err := json.Unmarshal(payload[1:], &msg); err != nil || msg.ID == "" {
+		countRejected("malformed_control")
+		return change
+	}
+	if authID != "" && authID != msg.ID {
+		countRejected("id_mismatch")
+		return change
+	}
+	if msg.ID == ps.id {
+		return change
+	}
+	// transports report the address the sender listens on, behind NAT
+	// that is the mapped address which replies have to go to
+	addr := from
+
+	switch payload[0] {
+	case JOIN_MSG_TYPE:
+		log.Printf("join from %s at %s", msg.ID, addr)
+		p, _ := ps.addActive(msg.ID, addr, &change)
+		reply := controlMsg{}
+		for _, i := range ps.Rand.Perm(len(ps.passive))[:min(shufflePassive, len(ps.passive))] {
+			reply.Entries = append(reply.Entries, ps.passive[i])
+		}
+		p.Send(ps.controlMsg(JOIN_REPLY_MSG_TYPE, reply))
+		forward := ps.controlMsg(FORWARD_JOIN_MSG_TYPE, controlMsg{
+			Joiner: &viewEntry{ID: msg.ID, Addr: addr},
+			TTL:    activeWalkLength,
+		})
+		for _, q := range ps.activePeers() {
+			if q.id != msg.ID {
+				q.Send(forward)
+			}
+		}
+
+	case JOIN_REPLY_MSG_TYPE:
+		ps.addActive(msg.ID, addr, &change)
+		for _, e := range msg.Entries {
+			ps.addPassive(e)
+		}
+
+	case FORWARD_JOIN_MSG_TYPE:
+		if msg.Joiner == nil || msg.Joiner.ID == ps.id {
+			return change
+		}
+		if msg.TTL <= 1 || ps.activeCount() <= 1 {
+			if ps.findPeerById(msg.Joiner.ID) == nil {
+				p, _ := ps.addActive(msg.Joiner.ID, msg.Joiner.Addr, &change)
+				p.Send(ps.controlMsg(JOIN_REPLY_MSG_TYPE, controlMsg{}))
+			}
+			return change
+		}
+		if msg.TTL == passiveWalkLength {
+			ps.addPassive(*msg.Joiner)
+		}
+		if next := ps.randomActive(msg.ID); next != nil {
+			next.Send(ps.controlMsg(FORWARD_JOIN_MSG_TYPE, controlMsg{Joiner: msg.Joiner, TTL: msg.TTL - 1}))
+		}
+
+	case NEIGHBOR_MSG_TYPE:
+		accept := msg.High || ps.activeCount() < ps.activeSize || ps.findPeerById(msg.ID) != nil
+		if accept {
+			ps.addActive(msg.ID, addr, &change)
+		}
+		ps.sendTo(msg.ID, addr, ps.controlMsg(NEIGHBOR_REPLY_MSG_TYPE, controlMsg{Accept: accept}))
+
+	case NEIGHBOR_REPLY_MSG_TYPE:
+		if _, ok := ps.pendingNeighbors[msg.ID]; !ok {
+			return change
+		}
+		delete(ps.pendingNeighbors, msg.ID)
+		if msg.Accept {
+			ps.addActive(msg.ID, addr, &change)
+		}
+
+	case DISCONNECT_MSG_TYPE:
+		for i := range ps.peers {
+			if ps.peers[i].id == msg.ID {
+				p := ps.peers[i]
+				if p.static {
+					break
+				}
+				log.Printf("peer %s disconnected", p.id)
+				ps.peers = slices.Delete(ps.peers, i, i+1)
+				ps.addPassive(viewEntry{ID: p.id, Addr: p.addr})
+				change.remove(p)
+				break
+			}
+		}
+
+	case SHUFFLE_MSG_TYPE:
+		origin := viewEntry{ID: msg.ID, Addr: addr}
+		if msg.Joiner != nil {
+			origin = *msg.Joiner
+		}
+		if msg.TTL > 1 && ps.activeCount() > 1 {
+			if next := ps.randomActive(msg.ID); next != nil {
+				next.Send(ps.controlMsg(SHUFFLE_MSG_TYPE, controlMsg{Joiner: &origin, TTL: msg.TTL - 1, Entries: msg.Entries}))
+				return change
+			}
+		}
+		reply := controlMsg{}
+		for _, i := range ps.Rand.Perm(len(ps.passive))[:min(len(msg.Entries), len(ps.passive))] {
+			reply.Entries = append(reply.Entries, ps.passive[i])
+		}
+		ps.sendTo(origin.ID, origin.Addr, ps.controlMsg(SHUFFLE_REPLY_MSG_TYPE, reply))
+		for _, e := range msg.Entries {
+			if e.ID == origin.ID {
+				e.Addr = origin.Addr
+			}
+			ps.addPassive(e)
+		}
+
+	case SHUFFLE_REPLY_MSG_TYPE:
+		for _, e := range msg.Entries {
+			ps.addPassive(e)
+		}
+
+	case PROBE_MSG_TYPE:
+		ps.onProbe(msg, addr, &change)
+
+	case PING_REQ_MSG_TYPE:
+		ps.onPingReq(msg, addr, ps.activeSender(addr, authID))
+
+	case PROBE_ACK_MSG_TYPE:
+		ps.onProbeAck(msg, addr, ps.activeSender(addr, authID), &change)
+
+	default:
+		countRejected("unknown_control")
+	}
+	return change
+}
@@ -0,0 +1,131 @@
+package peers
+
+import (
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hidera/clock"
+	"github.com/tamararankovic/hidera/config"
+)
+
+func ids(peers []Peer) []string {
+	ids := make([]string, 0, len(peers))
+	for _, p := range peers {
+		ids = append(ids, p.id)
+	}
+	return ids
+}
+
+func TestAddPeerReturnsViewChange(t *testing.T) {
+	conf := config.Config{NodeID: "b", TargetPeers: 1}
+	ps, err := NewPeersWithTransport(conf, NewMemNetwork(1).Transport("b:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	change := ps.AddPeer("x", "x:1")
+	if len(change.Added) != 1 || change.Added[0].id != "x" || len(change.Removed) != 0 {
+		t.Fatalf("got added %v, removed %v, want x added", ids(change.Added), ids(change.Removed))
+	}
+	if change := ps.AddPeer("x", "x:1"); !change.Empty() {
+		t.Fatalf("adding an active peer again changed the view: %+v", change)
+	}
+	change = ps.AddPeer("y", "y:1")
+	if len(change.Added) != 1 || change.Added[0].id != "y" || len(change.Removed) != 1 || change.Removed[0].id != "x" {
+		t.Fatalf("got added %v, removed %v, want y added and x removed", ids(change.Added), ids(change.Removed))
+	}
+}
+
+func TestRemovePeerReturnsViewChange(t *testing.T) {
+	conf := config.Config{NodeID: "b", PeersIDs: []string{"a"}, PeersAddrs: []string{"a:1"}}
+	ps, err := NewPeersWithTransport(conf, NewMemNetwork(1).Transport("b:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	change := ps.RemovePeer("a")
+	if len(change.Removed) != 1 || change.Removed[0].id != "a" || len(change.Added) != 0 {
+		t.Fatalf("got added %v, removed %v, want a removed", ids(change.Added), ids(change.Removed))
+	}
+	if len(ps.GetPeers()) != 0 {
+		t.Fatalf("%v still active", ids(ps.GetPeers()))
+	}
+	if change := ps.RemovePeer("a"); !change.Empty() {
+		t.Fatalf("removing an unknown peer changed the view: %+v", change)
+	}
+}
+
+// TestViewsStayWithinBounds lets nodes join through one seed and shuffle
+// for a while, the active and passive views never grow past their sizes.
+func TestViewsStayWithinBounds(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	network := NewMemNetwork(1)
+	network.Clock = c
+	network.MinLatency = time.Millisecond
+	network.MaxLatency = 20 * time.Millisecond
+	nodes := make([]*Peers, 0, 20)
+	for i := range 20 {
+		id := strconv.Itoa(i)
+		conf := config.Config{NodeID: id, TargetPeers: 3, PassivePeers: 6}
+		if i > 0 {
+			conf.Seeds = []string{"0:1"}
+		}
+		transport := network.Transport(id + ":1")
+		ps, err := NewPeersWithTransport(conf, transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps.Rand = rand.New(rand.NewSource(int64(i)))
+		transport.Handler = func(p Packet) { ps.Receive(p) }
+		t.Cleanup(func() { ps.Close() })
+		nodes = append(nodes, ps)
+	}
+	MessagesSentLock.Lock()
+	shuffles := MessagesSentByType[SHUFFLE_MSG_TYPE]
+	MessagesSentLock.Unlock()
+
+	check := func(tick int) {
+		for _, ps := range nodes {
+			ps.lock.Lock()
+			active, passive := ids(ps.activePeers()), slices.Clone(ps.passive)
+			ps.lock.Unlock()
+			if len(active) > ps.activeSize || len(passive) > ps.passiveSize {
+				t.Fatalf("tick %d: node %s has %d active and %d passive peers, want at most %d and %d",
+					tick, ps.id, len(active), len(passive), ps.activeSize, ps.passiveSize)
+			}
+			for _, e := range passive {
+				if e.ID == ps.id || slices.Contains(active, e.ID) {
+					t.Fatalf("tick %d: node %s has %s in its passive view, active %v", tick, ps.id, e.ID, active)
+				}
+			}
+			if slices.Contains(active, ps.id) {
+				t.Fatalf("tick %d: node %s is its own peer", tick, ps.id)
+			}
+		}
+	}
+	for tick := range 100 {
+		for _, ps := range nodes {
+			ps.Maintain()
+		}
+		for range 10 {
+			c.Advance(100 * time.Millisecond)
+			check(tick)
+		}
+	}
+
+	for _, ps := range nodes {
+		if len(ps.GetPeers()) == 0 || len(ps.passive) == 0 {
+			t.Errorf("node %s has %d active and %d passive peers", ps.id, len(ps.GetPeers()), len(ps.passive))
+		}
+	}
+	MessagesSentLock.Lock()
+	defer MessagesSentLock.Unlock()
+	if MessagesSentByType[SHUFFLE_MSG_TYPE] == shuffles {
+		t.Fatal("no shuffles")
+	}
+}
@@ -3,8 +3,50 @@ package peers
 import "sync"
 
 var (
-	MessagesSent     = 0
-	MessagesRcvd     = 0
-	MessagesSentLock = new(sync.Mutex)
-	MessagesRcvdLock = new(sync.Mutex)
+	MessagesSent       = 0
+	MessagesRcvd       = 0
+	MessagesSentByType = map[byte]int{}
+	MessagesRcvdByType = map[byte]int{}
+	MessagesSentLock   = new(sync.Mutex)
+	MessagesRcvdLock   = new(sync.Mutex)
 )
+
+func countSent(data []byte) {
+	MessagesSentLock.Lock()
+	defer MessagesSentLock.Unlock()
+	MessagesSent++
+	if len(data) > 0 {
+		MessagesSentByType[data[0]]++
+	}
+}
+
+func countRcvd(data []byte) {
+	MessagesRcvdLock.Lock()
+	defer MessagesRcvdLock.Unlock()
+	MessagesRcvd++
+	if len(data) > 0 {
+		MessagesRcvdByType[data[0]]++
+	}
+}
+
+var (
+	PacketsRejected     = map[string]int{}
+	PacketsRejectedLock = new(sync.Mutex)
+)
+
+func countRejected(reason string) {
+	PacketsRejectedLock.Lock()
+	PacketsRejected[reason]++
+	PacketsRejectedLock.Unlock()
+}
+
+var (
+	PacketsDropped     = map[string]int{}
+	PacketsDroppedLock = new(sync.Mutex)
+)
+
+func countDropped(reason string) {
+	PacketsDroppedLock.Lock()
+	PacketsDropped[reason]++
+	PacketsDroppedLock.Unlock()
+}
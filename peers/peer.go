@@ -1,29 +1,60 @@
 package peers
 
 import (
+	"crypto/cipher"
 	"log"
-	"net"
 )
 
 type Peer struct {
-	id     string
-	addr   *net.UDPAddr
-	conn   *net.UDPConn
-	failed bool
+	id        string
+	addr      string
+	transport Transport
+	auth      *authenticator
+	aead      cipher.AEAD
+	nonces    *nonceSource
+	failed    bool
+	// static peers come from the configuration and are never dropped
+	static bool
+	// failedTicks counts the calls to Maintain since the peer failed
+	failedTicks int
+	suspect     bool
+	// suspectTicks counts the calls to Maintain since the peer is suspected
+	suspectTicks int
+	// incarnation is the highest incarnation the peer has answered with
+	incarnation uint64
 }
 
 func (p *Peer) GetID() string {
 	return p.id
 }
 
+func (p *Peer) GetAddr() string {
+	return p.addr
+}
+
+func (p *Peer) IsFailed() bool {
+	return p.failed
+}
+
+func (p *Peer) IsSuspect() bool {
+	return p.suspect
+}
+
 func (p *Peer) Send(data []byte) {
-	go func() {
-		_, err := p.conn.WriteToUDP(data, p.addr)
-		if err != nil {
-			log.Println(err)
-		}
-		MessagesSentLock.Lock()
-		MessagesSent++
-		MessagesSentLock.Unlock()
-	}()
+	countSent(data)
+	if p.aead != nil {
+		data = encrypt(p.aead, p.nonces, data)
+	}
+	if p.auth != nil {
+		data = p.auth.seal(p.id, data)
+	}
+	if len(data) > p.transport.MaxPacketSize() {
+		log.Printf("dropping message to %s, %d bytes exceed the packet size", p.id, len(data))
+		countDropped("oversized")
+		return
+	}
+	if err := p.transport.Send(p.addr, data); err != nil {
+		log.Printf("send to %s failed: %v", p.id, err)
+		countDropped("send_failed")
+	}
 }
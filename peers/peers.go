@@ -1,8 +1,11 @@
 package peers
 
 import (
+	"crypto/cipher"
 	"log"
-	"net"
+	"math/rand"
+	"sync"
+	"time"
 
 	"github.com/tamararankovic/hidera/config"
 )
@@ -13,35 +16,104 @@ type MsgReceived struct {
 }
 
 type Peers struct {
-	peers     []Peer
-	conn      *net.UDPConn
-	Messages  chan MsgReceived
-	PeerAdded chan Peer
+	id               string
+	peers            []Peer
+	transport        Transport
+	Messages         chan MsgReceived
+	ViewChanges      chan ViewChange
+	auth             *authenticator
+	aead             cipher.AEAD
+	nonces           *nonceSource
+	seeds            []string
+	activeSize       int
+	passiveSize      int
+	passive          []viewEntry
+	pendingNeighbors map[string]int
+	ticks            int
+	incarnation      uint64
+	// Rand is the source of the randomness in the overlay and in probes,
+	// it is only used under the lock.
+	Rand *rand.Rand
+	lock *sync.Mutex
 }
 
 func NewPeers(config config.Config) (*Peers, error) {
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(config.ListenIP), Port: config.ListenPort})
+	transport, err := NewTransport(config)
 	if err != nil {
 		return nil, err
 	}
+	ps, err := NewPeersWithTransport(config, transport)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+	return ps, nil
+}
+
+func NewPeersWithTransport(config config.Config, transport Transport) (*Peers, error) {
 	ps := &Peers{
-		conn:      conn,
-		Messages:  make(chan MsgReceived, 1),
-		PeerAdded: make(chan Peer, 1),
+		id:               config.NodeID,
+		transport:        transport,
+		Messages:         make(chan MsgReceived, 1),
+		ViewChanges:      make(chan ViewChange, 1),
+		seeds:            config.Seeds,
+		activeSize:       max(config.TargetPeers, len(config.PeersIDs)),
+		passiveSize:      config.PassivePeers,
+		pendingNeighbors: make(map[string]int),
+		nonces:           newNonceSource(config.NodeID),
+		Rand:             rand.New(rand.NewSource(time.Now().UnixNano())),
+		lock:             new(sync.Mutex),
+	}
+	// statically configured peers are never trimmed from the active view
+	if ps.activeSize == 0 {
+		ps.activeSize = defaultActiveSize
+	}
+	if ps.passiveSize == 0 {
+		ps.passiveSize = passiveSizeFactor * ps.activeSize
+	}
+	if config.ClusterKey != nil {
+		ps.auth = newAuthenticator(config.NodeID, config.ClusterKey, config.PrevClusterKey, config.KeyGrace)
+	}
+	// peers that join at runtime use the cluster encryption key
+	if config.EncryptionKey != nil {
+		var err error
+		ps.aead, err = newAEAD(config.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
 	}
 	for i := range config.PeersIDs {
+		var aead cipher.AEAD
+		if i < len(config.PeersKeys) && config.PeersKeys[i] != nil {
+			var err error
+			aead, err = newAEAD(config.PeersKeys[i])
+			if err != nil {
+				return nil, err
+			}
+		}
 		ps.peers = append(ps.peers, Peer{
-			id:     config.PeersIDs[i],
-			addr:   &net.UDPAddr{IP: net.ParseIP(config.PeersIPs[i]), Port: config.ListenPort},
-			conn:   conn,
-			failed: false,
+			id:        config.PeersIDs[i],
+			addr:      config.PeersAddrs[i],
+			transport: transport,
+			auth:      ps.auth,
+			aead:      aead,
+			nonces:    ps.nonces,
+			failed:    false,
+			static:    true,
 		})
 	}
 	go ps.listen()
 	return ps, nil
 }
 
+// ID is the node ID the peers were created with.
+func (ps *Peers) ID() string {
+	return ps.id
+}
+
 func (ps *Peers) GetPeers() []Peer {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
 	active := make([]Peer, 0)
 	for _, p := range ps.peers {
 		if p.failed {
@@ -53,6 +125,8 @@ func (ps *Peers) GetPeers() []Peer {
 }
 
 func (ps *Peers) GetFailedPeers() []Peer {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
 	failed := make([]Peer, 0)
 	for _, p := range ps.peers {
 		if !p.failed {
@@ -64,6 +138,8 @@ func (ps *Peers) GetFailedPeers() []Peer {
 }
 
 func (ps *Peers) PeerFailed(id string) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
 	p := ps.findPeerById(id)
 	if p == nil {
 		return
@@ -71,48 +147,127 @@ func (ps *Peers) PeerFailed(id string) {
 	p.failed = true
 }
 
+func (ps *Peers) Close() error {
+	return ps.transport.Close()
+}
+
+// MaxMessageSize is the largest message that fits in a packet once it is
+// encrypted and signed.
+func (ps *Peers) MaxMessageSize() int {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	size := ps.transport.MaxPacketSize()
+	if ps.auth != nil {
+		size -= ps.auth.overhead()
+	}
+	overhead := 0
+	if ps.aead != nil {
+		overhead = aeadOverhead(ps.aead)
+	}
+	for _, p := range ps.peers {
+		if p.aead != nil {
+			overhead = max(overhead, aeadOverhead(p.aead))
+		}
+	}
+	return size - overhead
+}
+
 func (ps *Peers) listen() {
-	buf := make([]byte, 1472)
-	for {
-		n, sender, err := ps.conn.ReadFromUDP(buf)
-		if err != nil {
-			log.Println("read error:", err)
-			continue
+	for packet := range ps.transport.Packets() {
+		msg, change, ok := ps.Receive(packet)
+		// view changes share one channel so that they are applied in the
+		// order they happened
+		if !change.Empty() {
+			ps.ViewChanges <- change
 		}
-		MessagesRcvdLock.Lock()
-		MessagesRcvd++
-		MessagesRcvdLock.Unlock()
-		peer := ps.findPeerByAddr(sender)
-		if peer == nil {
-			log.Println("no peer found for address", sender)
-			continue
+		if ok {
+			ps.Messages <- msg
 		}
-		if peer.failed {
-			peer.failed = false
-			ps.PeerAdded <- *peer
+	}
+	close(ps.ViewChanges)
+	close(ps.Messages)
+}
+
+// Receive authenticates and decrypts a packet and finds its sender. It
+// returns the changes the packet made to the active view, and ok is false
+// if the packet was rejected or was a control message of the peer layer.
+// listen calls it for every packet of the transport, callers that deliver
+// packets themselves, like the simulator, call it directly.
+func (ps *Peers) Receive(packet Packet) (msg MsgReceived, change ViewChange, ok bool) {
+	sender := packet.From
+	var err error
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	payload := packet.Data
+	authID := ""
+	anyDst := false
+	var peer *Peer
+	if ps.auth != nil {
+		// signed packets are matched by the ID they carry, so the sender may
+		// be behind NAT or share its address with a node that left
+		authID, payload, anyDst, err = ps.auth.open(payload)
+		if err != nil {
+			countRejected(rejectReason(err))
+			log.Printf("rejected packet from %s: %v", sender, err)
+			return MsgReceived{}, change, false
 		}
-		data := make([]byte, n)
-		copy(data, buf[:n])
-		ps.Messages <- MsgReceived{
-			Sender:   *peer,
-			MsgBytes: data,
+		peer = ps.findPeerById(authID)
+	} else {
+		peer = ps.findPeerByAddr(sender)
+	}
+	aead := ps.aead
+	if peer != nil {
+		aead = peer.aead
+	}
+	if aead != nil {
+		payload, err = decrypt(aead, payload)
+		if err != nil {
+			countRejected(rejectReason(err))
+			log.Printf("rejected packet from %s: %v", sender, err)
+			return MsgReceived{}, change, false
 		}
 	}
+	// only joins are sent to seeds before their IDs are known
+	if anyDst && (len(payload) == 0 || payload[0] != JOIN_MSG_TYPE) {
+		countRejected("wrong_destination")
+		log.Printf("rejected packet from %s: not signed for this node", sender)
+		return MsgReceived{}, change, false
+	}
+	if isControlMsg(payload) {
+		countRcvd(payload)
+		return MsgReceived{}, ps.handleControl(sender, authID, payload), false
+	}
+	if peer == nil {
+		countRejected("unknown_sender")
+		log.Println("no peer found for address", sender)
+		return MsgReceived{}, change, false
+	}
+	countRcvd(payload)
+	if authID != "" && !sameAddr(peer.addr, sender) {
+		log.Printf("peer %s moved from %s to %s", peer.id, peer.addr, sender)
+		peer.addr = sender
+		change.move(*peer)
+	}
+	if peer.failed {
+		change.add(*peer)
+	}
+	peer.failed = false
+	return MsgReceived{Sender: *peer, MsgBytes: payload}, change, true
 }
 
-func (ps *Peers) findPeerByAddr(addr *net.UDPAddr) *Peer {
-	for _, p := range ps.peers {
-		if p.addr.IP.Equal(addr.IP) {
-			return &p
+func (ps *Peers) findPeerByAddr(addr string) *Peer {
+	for i := range ps.peers {
+		if sameAddr(ps.peers[i].addr, addr) {
+			return &ps.peers[i]
 		}
 	}
 	return nil
 }
 
 func (ps *Peers) findPeerById(id string) *Peer {
-	for _, p := range ps.peers {
-		if p.id == id {
-			return &p
+	for i := range ps.peers {
+		if ps.peers[i].id == id {
+			return &ps.peers[i]
 		}
 	}
 	return nil
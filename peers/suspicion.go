@@ -0,0 +1,162 @@
+package peers
+
+import (
+	"log"
+	"slices"
+)
+
+// Before a peer is failed it is suspected, as in SWIM. A suspected peer is
+// probed directly and through indirectProbes other active peers every call
+// to Maintain, and failed after suspicionTimeout calls unless it answers.
+// A probed node refutes the suspicion by answering with an incarnation
+// higher than the suspected one, so that answers sent before the suspicion
+// started do not clear it.
+const (
+	indirectProbes   = 3
+	suspicionTimeout = 3
+)
+
+// Suspect starts probing a peer that the protocol thinks has failed. It
+// returns false if the peer is unknown, already failed or already
+// suspected.
+func (ps *Peers) Suspect(id string) (Peer, bool) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	p := ps.findPeerById(id)
+	if p == nil || p.failed || p.suspect {
+		return Peer{}, false
+	}
+	log.Printf("suspecting peer %s at incarnation %d", p.id, p.incarnation)
+	p.suspect = true
+	p.suspectTicks = 0
+	ps.probe(*p)
+	return *p, true
+}
+
+// checkSuspects fails the suspected peers that have not answered in time
+// and probes the rest again.
+func (ps *Peers) checkSuspects(change *ViewChange) {
+	for i := range ps.peers {
+		p := &ps.peers[i]
+		if !p.suspect {
+			continue
+		}
+		p.suspectTicks++
+		if p.suspectTicks <= suspicionTimeout {
+			ps.probe(*p)
+			continue
+		}
+		log.Printf("suspected peer %s failed", p.id)
+		p.suspect = false
+		p.failed = true
+		p.failedTicks = 0
+		change.fail(*p)
+	}
+}
+
+func (ps *Peers) probe(p Peer) {
+	p.Send(ps.controlMsg(PROBE_MSG_TYPE, controlMsg{Incarnation: p.incarnation}))
+	candidates := make([]Peer, 0)
+	for _, q := range ps.peers {
+		if !q.failed && !q.suspect && q.id != p.id {
+			candidates = append(candidates, q)
+		}
+	}
+	req := ps.controlMsg(PING_REQ_MSG_TYPE, controlMsg{
+		Target:      &viewEntry{ID: p.id, Addr: p.addr},
+		Incarnation: p.incarnation,
+	})
+	for _, i := range ps.Rand.Perm(len(candidates))[:min(indirectProbes, len(candidates))] {
+		candidates[i].Send(req)
+	}
+}
+
+// onProbe answers a probe, relayed probes carry the node that asked for
+// them as Origin. A probe at the current incarnation means that this node
+// is suspected, so the incarnation is increased to refute it. The answer
+// tells whether the prober is still a peer, a failed prober is recovered
+// by a direct probe since that proves it is reachable.
+func (ps *Peers) onProbe(msg controlMsg, from string, change *ViewChange) {
+	if msg.Incarnation >= ps.incarnation {
+		ps.incarnation = msg.Incarnation + 1
+		log.Printf("refuting suspicion by %s with incarnation %d", msg.ID, ps.incarnation)
+	}
+	proberID := msg.ID
+	if msg.Origin != nil {
+		proberID = msg.Origin.ID
+	}
+	prober := ps.findPeerById(proberID)
+	if prober != nil && prober.failed && msg.Origin == nil {
+		prober.failed = false
+		change.add(*prober)
+	}
+	ps.sendTo(msg.ID, from, ps.controlMsg(PROBE_ACK_MSG_TYPE, controlMsg{
+		Origin:      msg.Origin,
+		Accept:      prober != nil,
+		Incarnation: ps.incarnation,
+	}))
+}
+
+// onPingReq probes the target on behalf of the sender. Only active peers
+// may ask, otherwise anyone could make this node send probes.
+func (ps *Peers) onPingReq(msg controlMsg, from string, sender *Peer) {
+	if sender == nil {
+		countRejected("unknown_sender")
+		return
+	}
+	if msg.Target == nil || msg.Target.ID == ps.id {
+		return
+	}
+	ps.sendTo(msg.Target.ID, msg.Target.Addr, ps.controlMsg(PROBE_MSG_TYPE, controlMsg{
+		Origin:      &viewEntry{ID: msg.ID, Addr: from},
+		Incarnation: msg.Incarnation,
+	}))
+}
+
+// onProbeAck relays the answer to a relayed probe to its origin, or clears
+// the suspicion of the peer that answered. Answers are only relayed to
+// active peers, and relayed answers are only accepted from them.
+func (ps *Peers) onProbeAck(msg controlMsg, from string, sender *Peer, change *ViewChange) {
+	if msg.Origin != nil {
+		origin := ps.findPeerById(msg.Origin.ID)
+		if origin == nil || origin.failed {
+			countRejected("unknown_sender")
+			return
+		}
+		origin.Send(ps.controlMsg(PROBE_ACK_MSG_TYPE, controlMsg{
+			Target:      &viewEntry{ID: msg.ID, Addr: from},
+			Accept:      msg.Accept,
+			Incarnation: msg.Incarnation,
+		}))
+		return
+	}
+	id := msg.ID
+	if msg.Target != nil {
+		if sender == nil {
+			countRejected("unknown_sender")
+			return
+		}
+		id = msg.Target.ID
+	}
+	p := ps.findPeerById(id)
+	if p == nil || msg.Incarnation <= p.incarnation {
+		return
+	}
+	p.incarnation = msg.Incarnation
+	if !p.suspect {
+		return
+	}
+	p.suspect = false
+	if msg.Accept || p.static {
+		log.Printf("suspected peer %s is alive at incarnation %d", p.id, p.incarnation)
+		change.alive(*p)
+		return
+	}
+	// the peer is alive but has dropped this node, so it is no longer a
+	// neighbour
+	log.Printf("suspected peer %s is alive but has dropped this node", p.id)
+	peer := *p
+	ps.peers = slices.DeleteFunc(ps.peers, func(q Peer) bool { return q.id == peer.id })
+	ps.addPassive(viewEntry{ID: peer.id, Addr: peer.addr})
+	change.remove(peer)
+}
@@ -0,0 +1,158 @@
+package peers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hidera/clock"
+	"github.com/tamararankovic/hidera/config"
+)
+
+func rawControlMsg(msgType byte, msg controlMsg) []byte {
+	msgBytes, _ := json.Marshal(msg)
+	return append([]byte{msgType}, msgBytes...)
+}
+
+// newTestPeers attaches a node with the given static peers to the network
+// and feeds its packets to Receive.
+func newTestPeers(t *testing.T, network *MemNetwork, id string, peers ...string) *Peers {
+	t.Helper()
+	conf := config.Config{NodeID: id}
+	for _, p := range peers {
+		conf.PeersIDs = append(conf.PeersIDs, p)
+		conf.PeersAddrs = append(conf.PeersAddrs, p+":1")
+	}
+	transport := network.Transport(id + ":1")
+	ps, err := NewPeersWithTransport(conf, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.Handler = func(p Packet) { ps.Receive(p) }
+	t.Cleanup(func() { ps.Close() })
+	return ps
+}
+
+// recorder attaches an address that records the types of the messages it
+// receives.
+func recorder(network *MemNetwork, addr string) (*MemTransport, *[]byte) {
+	var types []byte
+	t := network.Transport(addr)
+	t.Handler = func(p Packet) { types = append(types, p.Data[0]) }
+	return t, &types
+}
+
+func TestPingReqOnlyFromActivePeers(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	network := NewMemNetwork(1)
+	network.Clock = c
+	newTestPeers(t, network, "b", "a")
+	_, target := recorder(network, "c:1")
+	a := network.Transport("a:1")
+	stranger := network.Transport("s:1")
+	req := controlMsg{Target: &viewEntry{ID: "c", Addr: "c:1"}}
+
+	req.ID = "s"
+	stranger.Send("b:1", rawControlMsg(PING_REQ_MSG_TYPE, req))
+	c.Advance(time.Second)
+	if len(*target) != 0 {
+		t.Fatalf("ping request of a stranger was relayed")
+	}
+
+	req.ID = "a"
+	a.Send("b:1", rawControlMsg(PING_REQ_MSG_TYPE, req))
+	c.Advance(time.Second)
+	if len(*target) != 1 || (*target)[0] != PROBE_MSG_TYPE {
+		t.Fatalf("target got %v, want one probe", *target)
+	}
+}
+
+func TestRelayedProbeAckOnlyToAndFromActivePeers(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	network := NewMemNetwork(1)
+	network.Clock = c
+	newTestPeers(t, network, "b", "a")
+	_, origin := recorder(network, "a:1")
+	_, victim := recorder(network, "v:1")
+	target := network.Transport("c:1")
+
+	ack := controlMsg{ID: "c", Origin: &viewEntry{ID: "v", Addr: "v:1"}, Accept: true, Incarnation: 1}
+	target.Send("b:1", rawControlMsg(PROBE_ACK_MSG_TYPE, ack))
+	ack.Origin = &viewEntry{ID: "a", Addr: "v:1"}
+	target.Send("b:1", rawControlMsg(PROBE_ACK_MSG_TYPE, ack))
+	c.Advance(time.Second)
+	if len(*victim) != 0 {
+		t.Fatalf("acknowledgement relayed to %v, which is not an active peer", *victim)
+	}
+	if len(*origin) != 1 || (*origin)[0] != PROBE_ACK_MSG_TYPE {
+		t.Fatalf("origin got %v, want the relayed acknowledgement", *origin)
+	}
+}
+
+func TestRelayedProbeAckFromStrangerIgnored(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	network := NewMemNetwork(1)
+	network.Clock = c
+	a := newTestPeers(t, network, "a", "b", "c")
+	b, _ := recorder(network, "b:1")
+	recorder(network, "c:1")
+	if _, ok := a.Suspect("c"); !ok {
+		t.Fatal("could not suspect c")
+	}
+	stranger := network.Transport("s:1")
+	ack := controlMsg{ID: "s", Target: &viewEntry{ID: "c", Addr: "c:1"}, Accept: true, Incarnation: 1}
+	stranger.Send("a:1", rawControlMsg(PROBE_ACK_MSG_TYPE, ack))
+	c.Advance(time.Second)
+	if !a.findPeerById("c").IsSuspect() {
+		t.Fatal("relayed acknowledgement of a stranger cleared the suspicion")
+	}
+
+	ack.ID = "b"
+	b.Send("a:1", rawControlMsg(PROBE_ACK_MSG_TYPE, ack))
+	c.Advance(time.Second)
+	if a.findPeerById("c").IsSuspect() {
+		t.Fatal("relayed acknowledgement of an active peer did not clear the suspicion")
+	}
+}
+
+func TestProbeAckWithPeerKeys(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	network := NewMemNetwork(1)
+	network.Clock = c
+	newNode := func(id, peer string) *Peers {
+		conf := config.Config{
+			NodeID:        id,
+			PeersIDs:      []string{peer},
+			PeersAddrs:    []string{peer + ":1"},
+			PeersKeys:     [][]byte{[]byte("0123456789abcdef")},
+			EncryptionKey: []byte("fedcba9876543210"),
+			ClusterKey:    testKey,
+		}
+		transport := network.Transport(id + ":1")
+		ps, err := NewPeersWithTransport(conf, transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport.Handler = func(p Packet) { ps.Receive(p) }
+		t.Cleanup(func() { ps.Close() })
+		return ps
+	}
+	a := newNode("a", "b")
+	newNode("b", "a")
+	PacketsRejectedLock.Lock()
+	rejected := PacketsRejected["decrypt"]
+	PacketsRejectedLock.Unlock()
+
+	if _, ok := a.Suspect("b"); !ok {
+		t.Fatal("could not suspect b")
+	}
+	c.Advance(time.Second)
+	if a.findPeerById("b").IsSuspect() {
+		t.Fatal("acknowledgement encrypted with the peer key did not clear the suspicion")
+	}
+	PacketsRejectedLock.Lock()
+	defer PacketsRejectedLock.Unlock()
+	if PacketsRejected["decrypt"] != rejected {
+		t.Fatalf("%d packets could not be decrypted", PacketsRejected["decrypt"]-rejected)
+	}
+}
@@ -0,0 +1,281 @@
+package peers
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	MaxFrameSize  = 1 << 20
+	sendQueueSize = 128
+	dialTimeout   = 2 * time.Second
+	minBackoff    = 100 * time.Millisecond
+	maxBackoff    = 10 * time.Second
+	maxIdle       = 30 * time.Second
+)
+
+var (
+	errQueueFull = errors.New("send queue full")
+	errClosed    = errors.New("transport closed")
+	errIdle      = errors.New("connection idle")
+)
+
+// TCPTransport keeps one persistent outbound connection per peer and frames
+// packets with a 4 byte big endian length prefix. Packets are queued per peer
+// and written by the connection's goroutine, which reconnects with
+// exponential backoff. Packets queued while a connection breaks or a dial
+// fails are lost, like datagrams would be, and a connection that has nothing
+// to send after a failed dial or for maxIdle is closed and forgotten.
+// Close flushes the packets queued on open
+// connections before it returns, so that a leave message sent right before
+// it is not lost. A connection starts with the 2 byte port the
+// dialing node listens on, so that its packets are reported from its listen
+// address instead of the ephemeral port of the connection.
+type TCPTransport struct {
+	listener net.Listener
+	port     uint16
+	packets  chan Packet
+	conns    map[string]*tcpConn
+	inbound  map[net.Conn]struct{}
+	closed   bool
+	writers  *sync.WaitGroup
+	lock     *sync.Mutex
+	// maxIdle is replaced in tests
+	maxIdle time.Duration
+}
+
+type tcpConn struct {
+	addr    string
+	port    uint16
+	queue   chan []byte
+	done    chan struct{}
+	maxIdle time.Duration
+}
+
+func NewTCPTransport(ip string, port int) (*TCPTransport, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	t := &TCPTransport{
+		listener: listener,
+		port:     uint16(listener.Addr().(*net.TCPAddr).Port),
+		packets:  make(chan Packet, 1),
+		conns:    make(map[string]*tcpConn),
+		inbound:  make(map[net.Conn]struct{}),
+		writers:  new(sync.WaitGroup),
+		lock:     new(sync.Mutex),
+		maxIdle:  maxIdle,
+	}
+	go t.accept()
+	return t, nil
+}
+
+func (t *TCPTransport) Send(addr string, data []byte) error {
+	t.lock.Lock()
+	if t.closed {
+		t.lock.Unlock()
+		return errClosed
+	}
+	c := t.conns[addr]
+	if c == nil {
+		c = &tcpConn{
+			addr:    addr,
+			port:    t.port,
+			queue:   make(chan []byte, sendQueueSize),
+			done:    make(chan struct{}),
+			maxIdle: t.maxIdle,
+		}
+		t.conns[addr] = c
+		t.writers.Add(1)
+		go func() {
+			defer t.writers.Done()
+			t.run(c)
+		}()
+	}
+	// queued under the lock, so that retire does not miss the packet
+	defer t.lock.Unlock()
+	select {
+	case c.queue <- data:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// retire forgets the connection if nothing is queued for it.
+func (t *TCPTransport) retire(c *tcpConn) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(c.queue) > 0 {
+		return false
+	}
+	if t.conns[c.addr] == c {
+		delete(t.conns, c.addr)
+	}
+	return true
+}
+
+func (t *TCPTransport) Packets() <-chan Packet {
+	return t.packets
+}
+
+func (t *TCPTransport) MaxPacketSize() int {
+	return MaxFrameSize
+}
+
+func (t *TCPTransport) Close() error {
+	t.lock.Lock()
+	if t.closed {
+		t.lock.Unlock()
+		return nil
+	}
+	t.closed = true
+	for _, c := range t.conns {
+		close(c.done)
+	}
+	for conn := range t.inbound {
+		conn.Close()
+	}
+	err := t.listener.Close()
+	t.lock.Unlock()
+	t.writers.Wait()
+	return err
+}
+
+func (t *TCPTransport) accept() {
+	for {
+		conn, err := t.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Println("accept error:", err)
+			continue
+		}
+		t.lock.Lock()
+		if t.closed {
+			t.lock.Unlock()
+			conn.Close()
+			return
+		}
+		t.inbound[conn] = struct{}{}
+		t.lock.Unlock()
+		go t.read(conn)
+	}
+}
+
+func (t *TCPTransport) read(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		t.lock.Lock()
+		delete(t.inbound, conn)
+		t.lock.Unlock()
+	}()
+	r := bufio.NewReader(conn)
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header[:2]); err != nil {
+		return
+	}
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	from := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(header))))
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		size := binary.BigEndian.Uint32(header)
+		if size > MaxFrameSize {
+			log.Printf("closing connection from %s, frame of %d bytes", from, size)
+			return
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return
+		}
+		t.packets <- Packet{From: from, Data: data}
+	}
+}
+
+func (t *TCPTransport) run(c *tcpConn) {
+	backoff := minBackoff
+	for {
+		conn, err := net.DialTimeout("tcp", c.addr, dialTimeout)
+		if err != nil {
+			dropped := 0
+			for len(c.queue) > 0 {
+				<-c.queue
+				dropped++
+			}
+			log.Printf("dial %s failed, dropped %d packets: %v", c.addr, dropped, err)
+			select {
+			case <-time.After(backoff):
+			case <-c.done:
+				return
+			}
+			if t.retire(c) {
+				return
+			}
+			backoff = min(backoff*2, maxBackoff)
+			continue
+		}
+		backoff = minBackoff
+		err = c.write(conn)
+		conn.Close()
+		if err == nil {
+			return
+		}
+		if err == errIdle {
+			if t.retire(c) {
+				return
+			}
+			continue
+		}
+		log.Printf("connection to %s broken: %v", c.addr, err)
+	}
+}
+
+// write sends queued packets until the connection fails or is idle, or
+// flushes what is still queued and returns nil once the transport is closed.
+func (c *tcpConn) write(conn net.Conn) error {
+	w := bufio.NewWriter(conn)
+	w.Write(binary.BigEndian.AppendUint16(nil, c.port))
+	idle := time.NewTimer(c.maxIdle)
+	defer idle.Stop()
+	for {
+		select {
+		case <-idle.C:
+			return errIdle
+		case data := <-c.queue:
+			idle.Reset(c.maxIdle)
+			writeFrame(w, data)
+			// batch whatever else is already queued into the same flush
+			for len(c.queue) > 0 && w.Buffered() < MaxFrameSize {
+				writeFrame(w, <-c.queue)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		case <-c.done:
+			conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+			for len(c.queue) > 0 {
+				writeFrame(w, <-c.queue)
+			}
+			if err := w.Flush(); err != nil {
+				log.Printf("flushing packets to %s failed: %v", c.addr, err)
+			}
+			return nil
+		}
+	}
+}
+
+func writeFrame(w *bufio.Writer, data []byte) {
+	w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data))))
+	w.Write(data)
+}
@@ -0,0 +1,106 @@
+package peers
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTCPCloseFlushesQueuedPackets(t *testing.T) {
+	a, err := NewTCPTransport("127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewTCPTransport("127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(b.port)))
+
+	const n = 50
+	for i := range n {
+		if err := a.Send(addr, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.Close()
+	for i := range n {
+		select {
+		case p := <-b.Packets():
+			if p.Data[0] != byte(i) {
+				t.Fatalf("packet %d out of order: %v", i, p.Data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d of %d packets queued before Close", i, n)
+		}
+	}
+}
+
+// conns returns the number of outbound connections once it drops to want,
+// or after 5 seconds.
+func conns(t *TCPTransport, want int) int {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		t.lock.Lock()
+		n := len(t.conns)
+		t.lock.Unlock()
+		if n == want || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTCPForgetsIdleAndUnreachableAddresses(t *testing.T) {
+	a, err := NewTCPTransport("127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	a.maxIdle = 100 * time.Millisecond
+	b, err := NewTCPTransport("127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(b.port)))
+
+	if err := a.Send(addr, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-b.Packets():
+	case <-time.After(5 * time.Second):
+		t.Fatal("packet not received")
+	}
+	if n := conns(a, 0); n != 0 {
+		t.Fatalf("%d idle connections kept", n)
+	}
+	// the connection is dialed again for the next packet
+	if err := a.Send(addr, []byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-b.Packets():
+		if p.Data[0] != 2 {
+			t.Fatalf("got %v, want 2", p.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("packet after an idle connection was closed not received")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := l.Addr().String()
+	l.Close()
+	if err := a.Send(unreachable, []byte{3}); err != nil {
+		t.Fatal(err)
+	}
+	if n := conns(a, 0); n != 0 {
+		t.Fatalf("still dialing %d addresses without packets", n)
+	}
+}
@@ -0,0 +1,49 @@
+package peers
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/tamararankovic/hidera/config"
+)
+
+type Packet struct {
+	From string
+	Data []byte
+}
+
+// Transport moves opaque packets between nodes identified by "host:port"
+// addresses. Send must not block on the network, Peer.Send is called while
+// the protocol holds its lock.
+type Transport interface {
+	Send(addr string, data []byte) error
+	Packets() <-chan Packet
+	MaxPacketSize() int
+	Close() error
+}
+
+func NewTransport(conf config.Config) (Transport, error) {
+	switch conf.Transport {
+	case "", "udp":
+		return NewUDPTransport(conf.ListenIP, conf.ListenPort)
+	case "tcp":
+		return NewTCPTransport(conf.ListenIP, conf.ListenPort)
+	}
+	return nil, fmt.Errorf("unknown transport %q", conf.Transport)
+}
+
+// sameAddr reports whether two "host:port" addresses are the same, IP
+// addresses are compared by value so that different spellings of an IPv6
+// address match.
+func sameAddr(a, b string) bool {
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil || portA != portB {
+		return false
+	}
+	ipA, ipB := net.ParseIP(hostA), net.ParseIP(hostB)
+	if ipA == nil || ipB == nil {
+		return hostA == hostB
+	}
+	return ipA.Equal(ipB)
+}
@@ -0,0 +1,150 @@
+package peers
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// UDPTransport resolves the address of a node once and keeps it, it is
+// resolved again only after a send to it fails, e.g. because the node was
+// rescheduled to another IP. Addresses are resolved in the background so
+// that Send does not block on DNS, and failures are kept for resolveRetry.
+type UDPTransport struct {
+	conn    *net.UDPConn
+	packets chan Packet
+	addrs   map[string]*resolvedAddr
+	lock    *sync.Mutex
+	// resolveAddr is net.ResolveUDPAddr, replaced in tests
+	resolveAddr func(addr string) (*net.UDPAddr, error)
+}
+
+const (
+	resolveRetry = 5 * time.Second
+	// maxPendingSends bounds the packets kept for an address that is being
+	// resolved
+	maxPendingSends = 16
+)
+
+var errResolving = errors.New("too many packets waiting for the address to resolve")
+
+// resolvedAddr is an address in the cache, addr is nil while it is being
+// resolved and after resolving failed.
+type resolvedAddr struct {
+	addr    *net.UDPAddr
+	err     error
+	retry   time.Time
+	pending [][]byte
+}
+
+func NewUDPTransport(ip string, port int) (*UDPTransport, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(ip), Port: port})
+	if err != nil {
+		return nil, err
+	}
+	t := &UDPTransport{
+		conn:    conn,
+		packets: make(chan Packet, 1),
+		addrs:   make(map[string]*resolvedAddr),
+		lock:    new(sync.Mutex),
+		resolveAddr: func(addr string) (*net.UDPAddr, error) {
+			return net.ResolveUDPAddr("udp", addr)
+		},
+	}
+	go t.read()
+	return t, nil
+}
+
+func (t *UDPTransport) Send(addr string, data []byte) error {
+	t.lock.Lock()
+	r := t.addrs[addr]
+	if r != nil && r.addr != nil {
+		t.lock.Unlock()
+		_, err := t.conn.WriteToUDP(data, r.addr)
+		if err != nil {
+			t.forget(addr, r)
+		}
+		return err
+	}
+	if r == nil || (r.err != nil && time.Now().After(r.retry)) {
+		r = &resolvedAddr{}
+		t.addrs[addr] = r
+		go t.resolve(addr, r)
+	}
+	defer t.lock.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.pending) >= maxPendingSends {
+		return errResolving
+	}
+	r.pending = append(r.pending, data)
+	return nil
+}
+
+// resolve resolves the address and sends the packets that wait for it.
+func (t *UDPTransport) resolve(addr string, r *resolvedAddr) {
+	udpAddr, err := t.resolveAddr(addr)
+	t.lock.Lock()
+	pending := r.pending
+	r.pending = nil
+	if err != nil {
+		r.err = err
+		r.retry = time.Now().Add(resolveRetry)
+	} else {
+		r.addr = udpAddr
+	}
+	t.lock.Unlock()
+	if err != nil {
+		log.Printf("resolving %s failed, dropping %d packets: %v", addr, len(pending), err)
+		return
+	}
+	for _, data := range pending {
+		if _, err := t.conn.WriteToUDP(data, udpAddr); err != nil {
+			log.Printf("send to %s failed: %v", addr, err)
+			t.forget(addr, r)
+			return
+		}
+	}
+}
+
+// forget drops a resolved address after a send to it failed.
+func (t *UDPTransport) forget(addr string, r *resolvedAddr) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.addrs[addr] == r {
+		delete(t.addrs, addr)
+	}
+}
+
+func (t *UDPTransport) Packets() <-chan Packet {
+	return t.packets
+}
+
+func (t *UDPTransport) MaxPacketSize() int {
+	return MaxDatagramSize
+}
+
+func (t *UDPTransport) Close() error {
+	return t.conn.Close()
+}
+
+func (t *UDPTransport) read() {
+	defer close(t.packets)
+	buf := make([]byte, MaxDatagramSize)
+	for {
+		n, sender, err := t.conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Println("read error:", err)
+			continue
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		t.packets <- Packet{From: sender.String(), Data: data}
+	}
+}
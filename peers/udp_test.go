@@ -0,0 +1,98 @@
+package peers
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestUDPResolvesAddressesOnce(t *testing.T) {
+	a, err := NewUDPTransport("127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := NewUDPTransport("127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(b.conn.LocalAddr().(*net.UDPAddr).Port))
+
+	for i := range 3 {
+		if err := a.Send(addr, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case p := <-b.Packets():
+			if p.Data[0] != byte(i) {
+				t.Fatalf("got %v, want %d", p.Data, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("packet %d not received", i)
+		}
+	}
+	if len(a.addrs) != 1 {
+		t.Fatalf("%d cached addresses, want 1", len(a.addrs))
+	}
+	// a failed send forgets the address
+	a.conn.Close()
+	if err := a.Send(addr, []byte{0}); err == nil {
+		t.Fatal("send on a closed connection succeeded")
+	}
+	if len(a.addrs) != 0 {
+		t.Fatalf("address still cached after a failed send")
+	}
+}
+
+func TestUDPSendDoesNotWaitForResolution(t *testing.T) {
+	a, err := NewUDPTransport("127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	unblock := make(chan struct{})
+	resolved := make(chan struct{}, 10)
+	a.resolveAddr = func(addr string) (*net.UDPAddr, error) {
+		<-unblock
+		resolved <- struct{}{}
+		return nil, errors.New("no such host")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for range maxPendingSends {
+			if err := a.Send("unknown:1", []byte{0}); err != nil {
+				t.Error(err)
+			}
+		}
+		if err := a.Send("unknown:1", []byte{0}); err != errResolving {
+			t.Errorf("got %v with a full queue, want %v", err, errResolving)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send waits for the address to resolve")
+	}
+	close(unblock)
+	<-resolved
+
+	// the failure is kept instead of resolving on every send
+	deadline := time.Now().Add(5 * time.Second)
+	for err := a.Send("unknown:1", []byte{0}); err == nil || err == errResolving; err = a.Send("unknown:1", []byte{0}) {
+		if time.Now().After(deadline) {
+			t.Fatal("failed resolution not reported")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := a.Send("unknown:1", []byte{0}); err == nil || err == errResolving {
+		t.Fatalf("got %v after resolving failed", err)
+	}
+	if len(resolved) != 0 {
+		t.Fatalf("address resolved %d more times", len(resolved))
+	}
+}
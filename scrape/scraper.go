@@ -0,0 +1,83 @@
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/tamararankovic/hidera/clock"
+	"github.com/tamararankovic/hidera/config"
+	"github.com/tamararankovic/hidera/metrics"
+)
+
+const acceptHeader = "application/openmetrics-text;version=1.0.0;q=0.5,text/plain;version=0.0.4;q=0.4,*/*;q=0.1"
+
+// Scraper periodically pulls metrics from a local HTTP endpoint and hands the
+// selected samples to a callback, so hidera can run as a sidecar instead of
+// waiting for pushes. Clock times the scrapes.
+type Scraper struct {
+	Clock     clock.Clock
+	conf      config.ScrapeConfig
+	client    *http.Client
+	onSamples func([]metrics.Sample)
+}
+
+func NewScraper(conf config.ScrapeConfig, onSamples func([]metrics.Sample)) *Scraper {
+	return &Scraper{
+		Clock:     clock.Real{},
+		conf:      conf,
+		client:    &http.Client{Timeout: conf.Timeout},
+		onSamples: onSamples,
+	}
+}
+
+// Run scrapes right away and then every interval until ctx is done.
+func (s *Scraper) Run(ctx context.Context) {
+	log.Printf("[SCRAPE] scraping %s every %s", s.conf.URL, s.conf.Interval)
+	ticker := s.Clock.NewTicker(s.conf.Interval)
+	defer ticker.Stop()
+	for {
+		samples, err := s.Scrape(ctx)
+		if err != nil {
+			log.Printf("[SCRAPE] scrape of %s failed: %v", s.conf.URL, err)
+		} else {
+			s.onSamples(samples)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// Scrape fetches the target once and returns the samples of the selected
+// metrics, or all samples if no metrics are selected.
+func (s *Scraper) Scrape(ctx context.Context) ([]metrics.Sample, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.conf.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", acceptHeader)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	families, err := metrics.Parse(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	samples := metrics.Samples(families)
+	if len(s.conf.Metrics) == 0 {
+		return samples, nil
+	}
+	return slices.DeleteFunc(samples, func(sample metrics.Sample) bool {
+		return !slices.Contains(s.conf.Metrics, sample.Name)
+	}), nil
+}
@@ -0,0 +1,170 @@
+package scrape
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tamararankovic/hidera/clock"
+	"github.com/tamararankovic/hidera/config"
+	"github.com/tamararankovic/hidera/metrics"
+)
+
+const exposition = `# TYPE app_memory_usage_bytes gauge
+app_memory_usage_bytes{pod="a"} 512
+app_memory_usage_bytes{pod="b"} 256
+# TYPE http_requests_total counter
+http_requests_total{code="200"} 10
+# TYPE up gauge
+up 1
+`
+
+func serve(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func names(samples []metrics.Sample) []string {
+	names := make([]string, 0, len(samples))
+	for _, s := range samples {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestScrapeSelectsMetrics(t *testing.T) {
+	srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != acceptHeader {
+			t.Errorf("Accept header %q", r.Header.Get("Accept"))
+		}
+		io.WriteString(w, exposition)
+	})
+	tests := []struct {
+		name    string
+		metrics []string
+		want    []string
+	}{
+		{name: "all", want: []string{"app_memory_usage_bytes", "app_memory_usage_bytes", "http_requests_total", "up"}},
+		{name: "one", metrics: []string{"app_memory_usage_bytes"}, want: []string{"app_memory_usage_bytes", "app_memory_usage_bytes"}},
+		{name: "several", metrics: []string{"up", "http_requests_total"}, want: []string{"http_requests_total", "up"}},
+		{name: "missing", metrics: []string{"cpu_seconds_total"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := config.ScrapeConfig{URL: srv.URL, Interval: time.Second, Timeout: time.Second, Metrics: tt.metrics}
+			samples, err := NewScraper(conf, nil).Scrape(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(samples); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScrapeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{name: "status", handler: func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}},
+		{name: "malformed", handler: func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "up{ 1\n")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := config.ScrapeConfig{URL: serve(t, tt.handler).URL, Interval: time.Second, Timeout: time.Second}
+			if _, err := NewScraper(conf, nil).Scrape(context.Background()); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	defer close(release)
+	conf := config.ScrapeConfig{URL: srv.URL, Interval: time.Second, Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	if _, err := NewScraper(conf, nil).Scrape(context.Background()); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("scrape returned after %s with a timeout of %s", elapsed, conf.Timeout)
+	}
+}
+
+func TestRunScrapesEveryInterval(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	requests := make(chan struct{}, 10)
+	srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, exposition)
+		requests <- struct{}{}
+	})
+	received := make(chan []metrics.Sample, 10)
+	c := clock.NewFake(time.Unix(0, 0))
+	conf := config.ScrapeConfig{URL: srv.URL, Interval: 5 * time.Second, Timeout: time.Second, Metrics: []string{"up"}}
+	scraper := NewScraper(conf, func(samples []metrics.Sample) { received <- samples })
+	scraper.Clock = c
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		scraper.Run(ctx)
+		close(done)
+	}()
+
+	expect := func(want bool) {
+		t.Helper()
+		wait := time.After(100 * time.Millisecond)
+		if want {
+			wait = time.After(5 * time.Second)
+		}
+		select {
+		case <-requests:
+			if !want {
+				t.Fatalf("scraped before the interval passed")
+			}
+			if got := names(<-received); !slices.Equal(got, []string{"up"}) {
+				t.Fatalf("got samples %v, want only up", got)
+			}
+		case <-wait:
+			if want {
+				t.Fatalf("no scrape at %s", c.Now().Sub(time.Unix(0, 0)))
+			}
+		}
+	}
+
+	expect(true)
+	for range 3 {
+		c.Advance(conf.Interval - time.Millisecond)
+		expect(false)
+		c.Advance(time.Millisecond)
+		expect(true)
+	}
+
+	cancel()
+	c.Advance(conf.Interval)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the context was cancelled")
+	}
+}
@@ -0,0 +1,53 @@
+package sink
+
+import (
+	"log"
+
+	"github.com/caarlos0/env"
+)
+
+type Config struct {
+	Sinks              []string `env:"SINKS"                 envDefault:"csv"`
+	CSVDir             string   `env:"CSV_DIR"               envDefault:"/var/log/hidera"`
+	CSVValueColumns    []string `env:"CSV_VALUE_COLUMNS"`
+	CSVDistinctColumns []string `env:"CSV_DISTINCT_COLUMNS"`
+	CSVMsgCountColumns []string `env:"CSV_MSG_COUNT_COLUMNS"`
+	JSONLinesPath      string   `env:"JSONL_PATH"            envDefault:"/var/log/hidera/results.jsonl"`
+}
+
+func LoadConfigFromEnv() Config {
+	var c Config
+	if err := env.Parse(&c); err != nil {
+		log.Fatalln(err)
+	}
+	return c
+}
+
+// CSVColumns returns the configured columns by stream. Streams without
+// configured columns are written in the order of the record's fields.
+func (c Config) CSVColumns() map[string][]string {
+	columns := make(map[string][]string)
+	if len(c.CSVValueColumns) > 0 {
+		columns["value"] = c.CSVValueColumns
+	}
+	if len(c.CSVDistinctColumns) > 0 {
+		columns["distinct"] = c.CSVDistinctColumns
+	}
+	if len(c.CSVMsgCountColumns) > 0 {
+		columns["msg_count"] = c.CSVMsgCountColumns
+	}
+	return columns
+}
+
+func FromConfig(conf Config) (Multi, error) {
+	sinks := make(Multi, 0, len(conf.Sinks))
+	for _, name := range conf.Sinks {
+		s, err := New(name, conf)
+		if err != nil {
+			sinks.Close()
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
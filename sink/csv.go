@@ -0,0 +1,83 @@
+package sink
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CSVSink appends each stream to <dir>/<stream>.csv. The files have no
+// header row, so that they stay valid when a restarted node appends to them.
+type CSVSink struct {
+	dir     string
+	columns map[string][]string
+	files   map[string]*os.File
+	writers map[string]*csv.Writer
+	closed  bool
+	lock    *sync.Mutex
+}
+
+func NewCSVSink(dir string, columns map[string][]string) *CSVSink {
+	return &CSVSink{
+		dir:     dir,
+		columns: columns,
+		files:   make(map[string]*os.File),
+		writers: make(map[string]*csv.Writer),
+		lock:    new(sync.Mutex),
+	}
+}
+
+func (s *CSVSink) Write(record Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	writer := s.writers[record.Stream]
+	if writer == nil {
+		file, err := os.OpenFile(filepath.Join(s.dir, record.Stream+".csv"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			return err
+		}
+		writer = csv.NewWriter(file)
+		s.files[record.Stream] = file
+		s.writers[record.Stream] = writer
+	}
+	row := make([]string, 0)
+	if columns, ok := s.columns[record.Stream]; ok {
+		for _, c := range columns {
+			value, _ := record.Get(c)
+			row = append(row, value)
+		}
+	} else {
+		for _, f := range record.Fields {
+			row = append(row, f.Value)
+		}
+	}
+	if err := writer.Write(row); err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (s *CSVSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	var err error
+	for stream, writer := range s.writers {
+		writer.Flush()
+		if werr := writer.Error(); werr != nil && err == nil {
+			err = werr
+		}
+		if cerr := s.files[stream].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
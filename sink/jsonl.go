@@ -0,0 +1,63 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// JSONLinesSink writes every record as a JSON object on its own line, with
+// the stream name in the "stream" key.
+type JSONLinesSink struct {
+	file   *os.File
+	buf    *bufio.Writer
+	closed bool
+	lock   *sync.Mutex
+}
+
+func NewJSONLinesSink(path string) (*JSONLinesSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLinesSink{
+		file: file,
+		buf:  bufio.NewWriter(file),
+		lock: new(sync.Mutex),
+	}, nil
+}
+
+func (s *JSONLinesSink) Write(record Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	obj := make(map[string]string, len(record.Fields)+1)
+	for _, f := range record.Fields {
+		obj[f.Name] = f.Value
+	}
+	obj["stream"] = record.Stream
+	line, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	s.buf.Write(line)
+	s.buf.WriteByte('\n')
+	return s.buf.Flush()
+}
+
+func (s *JSONLinesSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	err := s.buf.Flush()
+	if cerr := s.file.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Record is one row of a result stream, e.g. the aggregate of a metric or
+// the message counters at some point in time.
+type Record struct {
+	Stream string
+	Fields []Field
+}
+
+func NewRecord(stream string) *Record {
+	return &Record{Stream: stream}
+}
+
+func (r *Record) Add(name, value string) *Record {
+	r.Fields = append(r.Fields, Field{Name: name, Value: value})
+	return r
+}
+
+func (r Record) Get(name string) (string, bool) {
+	for _, f := range r.Fields {
+		if f.Name == name {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+type Sink interface {
+	Write(record Record) error
+	Close() error
+}
+
+// Multi writes every record to all of its sinks.
+type Multi []Sink
+
+func (m Multi) Write(record Record) error {
+	errs := make([]error, 0)
+	for _, s := range m {
+		errs = append(errs, s.Write(record))
+	}
+	return errors.Join(errs...)
+}
+
+func (m Multi) Close() error {
+	errs := make([]error, 0)
+	for _, s := range m {
+		errs = append(errs, s.Close())
+	}
+	return errors.Join(errs...)
+}
+
+var ErrClosed = errors.New("sink closed")
+
+func New(name string, conf Config) (Sink, error) {
+	switch name {
+	case "csv":
+		return NewCSVSink(conf.CSVDir, conf.CSVColumns()), nil
+	case "jsonl":
+		return NewJSONLinesSink(conf.JSONLinesPath)
+	case "stdout":
+		return NewStdoutSink(), nil
+	}
+	return nil, fmt.Errorf("unknown sink %q", name)
+}
@@ -0,0 +1,187 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestCSVSinkColumns(t *testing.T) {
+	dir := t.TempDir()
+	s := NewCSVSink(dir, map[string][]string{"value": {"b", "a", "missing"}})
+	if err := s.Write(*NewRecord("value").Add("a", "1").Add("b", "2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(*NewRecord("msg_count").Add("a", "1").Add("b", "2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// a restarted node appends rows without a header
+	s = NewCSVSink(dir, map[string][]string{"value": {"b", "a", "missing"}})
+	if err := s.Write(*NewRecord("value").Add("b", "4").Add("a", "3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := readLines(t, filepath.Join(dir, "value.csv")), []string{"2,1,", "4,3,"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("value.csv has %q, want %q", got, want)
+	}
+	if got, want := readLines(t, filepath.Join(dir, "msg_count.csv")), []string{"1,2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("msg_count.csv has %q, want the fields in record order %q", got, want)
+	}
+	if err := s.Write(*NewRecord("value")); err != ErrClosed {
+		t.Errorf("got %v writing to a closed sink, want %v", err, ErrClosed)
+	}
+}
+
+func TestJSONLinesSinkRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	s, err := NewJSONLinesSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []Record{
+		*NewRecord("value").Add("metric", `say "hi"`).Add("avg", "1.5"),
+		*NewRecord("msg_count").Add("sent", "10"),
+	}
+	for _, r := range records {
+		if err := s.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, path)
+	if len(lines) != len(records) {
+		t.Fatalf("%d lines, want %d", len(lines), len(records))
+	}
+	for i, line := range lines {
+		var got map[string]string
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{"stream": records[i].Stream}
+		for _, f := range records[i].Fields {
+			want[f.Name] = f.Value
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("line %d is %v, want %v", i, got, want)
+		}
+	}
+	if err := s.Write(records[0]); err != ErrClosed {
+		t.Errorf("got %v writing to a closed sink, want %v", err, ErrClosed)
+	}
+}
+
+func TestStdoutSinkQuotesValues(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	s := NewStdoutSink()
+	err = s.Write(*NewRecord("value").Add("metric", "cpu").Add("labels", `a="b c"`))
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, _ := bufio.NewReader(r).ReadString('\n')
+	if want := `value metric=cpu labels="a=\"b c\""` + "\n"; line != want {
+		t.Errorf("printed %q, want %q", line, want)
+	}
+	s.Close()
+	if err := s.Write(*NewRecord("value")); err != ErrClosed {
+		t.Errorf("got %v writing to a closed sink, want %v", err, ErrClosed)
+	}
+}
+
+// fakeSink records its records and fails with err.
+type fakeSink struct {
+	records []Record
+	closed  bool
+	err     error
+}
+
+func (s *fakeSink) Write(record Record) error {
+	s.records = append(s.records, record)
+	return s.err
+}
+
+func (s *fakeSink) Close() error {
+	s.closed = true
+	return s.err
+}
+
+func TestMultiWritesToAllSinks(t *testing.T) {
+	errFailed := errors.New("failed")
+	failing, ok := &fakeSink{err: errFailed}, &fakeSink{}
+	m := Multi{failing, ok}
+
+	if err := m.Write(*NewRecord("value")); !errors.Is(err, errFailed) {
+		t.Fatalf("got %v, want the error of the failing sink", err)
+	}
+	if len(ok.records) != 1 {
+		t.Fatal("a failing sink kept the record from the others")
+	}
+	if err := m.Close(); !errors.Is(err, errFailed) {
+		t.Fatalf("got %v closing, want the error of the failing sink", err)
+	}
+	if !failing.closed || !ok.closed {
+		t.Fatal("not every sink was closed")
+	}
+	if err := (Multi{}).Write(*NewRecord("value")); err != nil {
+		t.Fatalf("empty Multi failed: %v", err)
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	dir := t.TempDir()
+	conf := Config{Sinks: []string{"csv", "jsonl", "stdout"}, CSVDir: dir, JSONLinesPath: filepath.Join(dir, "results.jsonl")}
+	sinks, err := FromConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sinks) != 3 {
+		t.Fatalf("%d sinks, want 3", len(sinks))
+	}
+	for i, want := range []Sink{&CSVSink{}, &JSONLinesSink{}, &StdoutSink{}} {
+		if reflect.TypeOf(sinks[i]) != reflect.TypeOf(want) {
+			t.Errorf("sink %d is %T, want %T", i, sinks[i], want)
+		}
+	}
+	if err := sinks.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.Sinks = []string{"jsonl", "kafka"}
+	if _, err := FromConfig(conf); err == nil || !strings.Contains(err.Error(), "kafka") {
+		t.Fatalf("got %v for an unknown sink", err)
+	}
+	conf.Sinks = []string{"jsonl"}
+	conf.JSONLinesPath = filepath.Join(dir, "missing", "results.jsonl")
+	if _, err := FromConfig(conf); err == nil {
+		t.Fatal("jsonl sink in a missing directory created")
+	}
+}
@@ -0,0 +1,46 @@
+package sink
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// StdoutSink prints records as "stream name=value ..." lines.
+type StdoutSink struct {
+	closed bool
+	lock   *sync.Mutex
+}
+
+func NewStdoutSink() *StdoutSink {
+	return &StdoutSink{lock: new(sync.Mutex)}
+}
+
+func (s *StdoutSink) Write(record Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	var b strings.Builder
+	b.WriteString(record.Stream)
+	for _, f := range record.Fields {
+		b.WriteString(" " + f.Name + "=")
+		if strings.ContainsAny(f.Value, " \"=") {
+			b.WriteString(strconv.Quote(f.Value))
+		} else {
+			b.WriteString(f.Value)
+		}
+	}
+	b.WriteString("\n")
+	_, err := os.Stdout.WriteString(b.String())
+	return err
+}
+
+func (s *StdoutSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	return nil
+}